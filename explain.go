@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/clienthelpers"
+	"github.com/jukie/karpenter-deprovision-controller/pkg/controller"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const explainUsage = `Usage: %s explain [flags] node NAME
+       %s explain [flags] pod NAMESPACE/NAME
+
+Explains why a node is or isn't having its blocking pods unblocked, evaluating it the way the controller would
+without changing anything. Pass the controller's flags, e.g. --config or --unblock-reasons, so the same settings
+apply. Flags must come before the node or pod.
+
+Flags:
+`
+
+// explain runs the explain subcommand and returns the exit code.
+func explain(args []string) int {
+	fs := flag.NewFlagSet("explain", flag.ContinueOnError)
+	registerFlags(fs)
+	var kubeconfig, kubeContext, output string
+	fs.StringVar(&kubeconfig, "kubeconfig", "", "Path to the kubeconfig file. Defaults to KUBECONFIG or ~/.kube/config")
+	fs.StringVar(&kubeContext, "context", "", "Kubeconfig context to use. Defaults to the current context")
+	fs.StringVar(&output, "output", "table", "Output format: table or json")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), explainUsage, filepath.Base(os.Args[0]), filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return 0
+	} else if err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 || (output != "table" && output != "json") {
+		fs.Usage()
+		return 2
+	}
+	kind, name := fs.Arg(0), fs.Arg(1)
+
+	ctx := context.Background()
+	log.SetLogger(klog.NewKlogr())
+	restConfig, err := clienthelpers.GetKubeconfig(kubeconfig, kubeContext)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	c, err := client.New(restConfig, client.Options{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed creating client: %v\n", err)
+		return 1
+	}
+	controllerConfig, configWatcher := loadControllerConfig(ctx)
+	// Explaining never writes, the dry-run client makes sure of it
+	nController := newDeprovisionController(ctx, client.NewDryRunClient(c), configWatcher)
+	nController.ApplyConfig(controllerConfig)
+
+	var explanation *controller.Explanation
+	switch kind {
+	case "node", "nodes", "no":
+		explanation, err = nController.Explain(ctx, name, time.Now())
+	case "pod", "pods", "po":
+		namespace, podName, ok := strings.Cut(name, "/")
+		if !ok {
+			fmt.Fprintf(os.Stderr, "pods must be given as NAMESPACE/NAME, got %q\n", name)
+			return 2
+		}
+		explanation, err = nController.ExplainPod(ctx, types.NamespacedName{Namespace: namespace, Name: podName}, time.Now())
+	default:
+		fs.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(explanation)
+	} else {
+		err = printExplanation(os.Stdout, explanation)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// printExplanation writes the explanation as a decision trace with a table each for events and pods.
+func printExplanation(out io.Writer, e *controller.Explanation) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	node := e.Node
+	if !e.NodeFound {
+		node += " (not found)"
+	}
+	fmt.Fprintf(w, "Node:\t%s\n", node)
+	fmt.Fprintf(w, "NodeClaim:\t%s\n", orNone(e.NodeClaim))
+	fmt.Fprintf(w, "NodePool:\t%s\n", orNone(e.NodePool))
+	switch {
+	case e.Reason == "":
+		fmt.Fprintf(w, "Disruption reason:\t<none>\n")
+	case e.ReasonUnblocks:
+		fmt.Fprintf(w, "Disruption reason:\t%s since %s, configured for unblocking\n", e.Reason, formatTime(e.DisruptedSince))
+	default:
+		fmt.Fprintf(w, "Disruption reason:\t%s since %s, not configured for unblocking\n", e.Reason, formatTime(e.DisruptedSince))
+	}
+	fmt.Fprintf(w, "Blackout:\t%s\n", orNone(e.Blackout))
+	if e.BudgetsAllow != nil {
+		fmt.Fprintf(w, "NodePool budgets:\tallowed=%t\n", *e.BudgetsAllow)
+	}
+
+	fmt.Fprintf(w, "\nMatched events:\n")
+	if len(e.Events) == 0 {
+		fmt.Fprintf(w, "  <none>\n")
+	} else {
+		fmt.Fprintf(w, "  NAMESPACE\tNAME\tREASON\tCOUNT\tLAST SEEN\tMATCHER\tMESSAGE\n")
+		for _, event := range e.Events {
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%d\t%s\t%s\t%s\n", event.Namespace, event.Name, event.Reason, event.Count, formatTime(event.LastSeen), event.Matcher, event.Message)
+		}
+	}
+
+	fmt.Fprintf(w, "\nBlocking pods:\n")
+	if len(e.Pods) == 0 {
+		fmt.Fprintf(w, "  <none>\n")
+	} else {
+		fmt.Fprintf(w, "  NAMESPACE\tNAME\tDECISION\tPOLICY\tSCHEDULE\tDURATION\tTIMEZONE\tWINDOW\tNEXT WINDOW\tDETAILS\n")
+		for _, pod := range e.Pods {
+			window := "-"
+			if pod.WindowStart != nil {
+				window = formatTime(pod.WindowStart) + " - " + formatTime(pod.WindowEnd)
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", pod.Namespace, pod.Name, pod.Decision, orDash(pod.Policy), orDash(pod.Schedule),
+				formatDuration(pod.WindowDuration), orDash(pod.Timezone), window, formatTime(pod.NextWindow), podDetails(pod))
+		}
+	}
+
+	fmt.Fprintf(w, "\nVerdict:\t%s\n", e.Verdict)
+	for _, note := range e.Notes {
+		fmt.Fprintf(w, "Note:\t%s\n", note)
+	}
+	return w.Flush()
+}
+
+// podDetails describes what else went into the pod's decision.
+func podDetails(pod controller.PodExplanation) string {
+	var details []string
+	if pod.InvalidWindow != "" {
+		details = append(details, pod.InvalidWindow)
+	}
+	if pod.Blackout != "" {
+		details = append(details, "blackout "+pod.Blackout)
+	}
+	if pod.MaxBlockDuration != nil {
+		details = append(details, fmt.Sprintf("max block duration %s until %s", pod.MaxBlockDuration.Duration, formatTime(pod.MaxBlockDeadline)))
+	}
+	if pod.HeldByPDB != "" {
+		details = append(details, "held by pod disruption budget "+pod.HeldByPDB)
+	}
+	if pod.Error != "" {
+		details = append(details, pod.Error)
+	}
+	if len(details) == 0 {
+		return "-"
+	}
+	return strings.Join(details, ", ")
+}
+
+func formatTime(t *metav1.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatDuration(d *metav1.Duration) string {
+	if d == nil {
+		return "-"
+	}
+	return d.Duration.String()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
@@ -7,26 +7,112 @@ import (
 	"github.com/jukie/karpenter-deprovision-controller/pkg/controller"
 	"github.com/jukie/karpenter-deprovision-controller/pkg/metrics"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/discovery"
 	"k8s.io/klog/v2"
+	"os"
 	"os/signal"
 	ctrlruntime "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"strings"
 	"syscall"
 	"time"
+	// Embedded so disruption window timezones resolve on distroless images
+	_ "time/tzdata"
 )
 
 var (
-	dryRun     bool
-	syncPeriod = 60 * time.Minute
-	opts       = client.Options{}
+	dryRun              bool
+	reconcileEvents     bool
+	reconcileNodeClaims bool
+	unblockReasons      string
+	disruptionPolicies  bool
+	blackoutFile        string
+	restoreAnnotations  bool
+	maxUnblockedNodes   int
+	maxUnblockedPerPool int
+	evictAfter          time.Duration
+	maxBlockDuration    time.Duration
+	eventSource         string
+	karpenterVersion    string
+	eventMatchersFile   string
+	configFile          string
+	annotationPrefix    string
+	deprecatedPrefixes  string
+	leaderElect         bool
+	leaderElectionNS    string
+	leaderElectionID    string
+	leaseDuration       time.Duration
+	renewDeadline       time.Duration
+	retryPeriod         time.Duration
+	probeAddr           string
+	metricsAddr         string
+	metricsSecure       bool
+	metricsCertDir      string
+	metricsCertName     string
+	metricsKeyName      string
+	eventVerbosity      string
+	enableWebhook       bool
+	webhookMode         string
+	webhookPort         int
+	webhookCertDir      string
+	webhookCertName     string
+	webhookKeyName      string
+	invalidWindow       string
+	defaultSchedule     string
+	opts                = client.Options{}
 )
 
+// registerFlags registers the controller's flags. explain accepts them too so it evaluates nodes with the same settings.
+func registerFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&dryRun, "dry-run", true, "Whether or not to execute do-not-disrupt pod annotation removals. No Events are recorded and nothing is counted in the patch, eviction or unblock metrics in dry-run mode. Defaults to true")
+	fs.BoolVar(&reconcileEvents, "reconcile-events", true, "Whether or not to unblock nodes referenced by Karpenter DisruptionBlocked events. Defaults to true")
+	fs.BoolVar(&reconcileNodeClaims, "reconcile-nodeclaims", true, "Whether or not to unblock nodes by watching NodeClaim expiration and drift directly. Defaults to true")
+	fs.StringVar(&unblockReasons, "unblock-reasons", "Expired,Drifted,Deleting", "Comma-separated disruption reasons (Expired, Drifted, Deleting, Underutilized, Empty, Blocked) for which do-not-disrupt annotations may be removed. Defaults to Expired,Drifted,Deleting")
+	fs.BoolVar(&disruptionPolicies, "enable-disruption-policies", false, "Whether or not to read disruption windows from DisruptionPolicy resources. Requires the DisruptionPolicy CRD to be installed. Defaults to false")
+	fs.StringVar(&blackoutFile, "blackout-file", "", "Path to a YAML or iCalendar (.ics) blackout calendar, e.g. from a mounted ConfigMap. Do-not-disrupt annotations are never removed during a blackout. Changes are applied without a restart")
+	fs.BoolVar(&restoreAnnotations, "restore-annotations", true, "Whether or not to restore removed do-not-disrupt annotations when the disruption window closes before the node is disrupted. Defaults to true")
+	fs.IntVar(&maxUnblockedNodes, "max-unblocked-nodes", 0, "Maximum number of nodes across the cluster that may have do-not-disrupt annotations removed without having terminated yet. 0 means unlimited")
+	fs.IntVar(&maxUnblockedPerPool, "max-unblocked-nodes-per-nodepool", 0, "Maximum number of nodes per NodePool that may have do-not-disrupt annotations removed without having terminated yet. 0 means unlimited")
+	fs.DurationVar(&evictAfter, "evict-after", 0, "Cordon nodes still running this long after expiring and evict their unblocked pods. Evictions only happen inside an active disruption window and respect PodDisruptionBudgets. 0 disables eviction")
+	fs.DurationVar(&maxBlockDuration, "max-block-duration", 0, "How long after a node starts being disrupted its blocking pods are unblocked even outside their disruption window. Pods can shorten it with the "+controller.MaxBlockDurationKey+" annotation, read under --annotation-prefix when set, but never extend it. 0 means pods may block forever unless their annotation sets a limit")
+	fs.StringVar(&eventSource, "event-source", controller.EventSourceAuto, "Event API to read DisruptionBlocked events from: core/v1, events.k8s.io/v1, or auto to use events.k8s.io/v1 when the cluster serves it. Defaults to auto")
+	fs.StringVar(&karpenterVersion, "karpenter-version", "", "Karpenter API version whose built-in event matchers to use. Only v1 is supported since the controller reads v1 NodeClaims. Defaults to matching the events of every known version")
+	fs.StringVar(&eventMatchersFile, "event-matchers-file", "", "Path to a YAML file of event matcher rules (reason, kind, message regex) replacing the built-in ones")
+	fs.StringVar(&annotationPrefix, "annotation-prefix", controller.DefaultAnnotationPrefix, "Domain of the pod annotation keys, e.g. disruption-window-schedule is read from <prefix>/disruption-window-schedule. Defaults to k8s.adsrvr.net")
+	fs.StringVar(&deprecatedPrefixes, "deprecated-annotation-prefixes", "", "Comma-separated annotation prefixes still read while migrating to --annotation-prefix. Reads from them are counted in deprecated_annotations_total")
+	fs.BoolVar(&leaderElect, "leader-elect", false, "Whether or not to elect a leader through a Lease so only one of several replicas reconciles. Defaults to false")
+	fs.StringVar(&leaderElectionNS, "leader-election-namespace", "", "Namespace of the leader election Lease. Defaults to the namespace the controller runs in")
+	fs.StringVar(&leaderElectionID, "leader-election-id", "karpenter-deprovision-controller", "Name of the leader election Lease")
+	fs.DurationVar(&leaseDuration, "leader-election-lease-duration", 15*time.Second, "How long standby replicas wait before taking over a Lease that wasn't renewed")
+	fs.DurationVar(&renewDeadline, "leader-election-renew-deadline", 10*time.Second, "How long the leader keeps retrying to renew its Lease before giving up leadership")
+	fs.DurationVar(&retryPeriod, "leader-election-retry-period", 2*time.Second, "How long replicas wait between attempts to acquire or renew the Lease")
+	fs.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "Address serving the /healthz and /readyz probe endpoints. 0 disables them")
+	fs.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "Address serving the /metrics endpoint. 0 disables it")
+	fs.BoolVar(&metricsSecure, "metrics-secure", false, "Whether or not to serve metrics over HTTPS, only allowing clients authorized to get the /metrics non-resource URL. Defaults to false")
+	fs.StringVar(&metricsCertDir, "metrics-cert-dir", "", "Directory with the certificate and key serving metrics over HTTPS, e.g. from a mounted Secret. A self-signed certificate is generated when empty")
+	fs.StringVar(&metricsCertName, "metrics-cert-name", "tls.crt", "File name of the metrics certificate in --metrics-cert-dir")
+	fs.StringVar(&metricsKeyName, "metrics-key-name", "tls.key", "File name of the metrics key in --metrics-cert-dir")
+	fs.StringVar(&eventVerbosity, "event-verbosity", string(controller.EventVerbosityNormal), "Which decisions are recorded as Kubernetes Events on pods and nodes: Off, Normal for removed annotations, invalid disruption windows, cordons and evictions, or Verbose to also record pods left blocking until their disruption window opens. Defaults to Normal")
+	fs.StringVar(&invalidWindow, "invalid-window-policy", string(controller.InvalidWindowFailOpen), "How disruption windows with an invalid schedule, duration or timezone are treated: FailOpen treats invalid schedules and timezones as always active, FailClosed treats invalid windows as never active, DefaultWindow uses --default-window-schedule instead. Override it per namespace with windows.namespaceInvalidPolicies in the configuration file. Defaults to FailOpen")
+	fs.StringVar(&defaultSchedule, "default-window-schedule", "", "Disruption window schedule in cron format, evaluated in UTC, used instead of invalid windows with the DefaultWindow policy. It lasts the default window duration")
+	fs.BoolVar(&enableWebhook, "enable-webhook", false, "Whether or not to serve a validating admission webhook for the disruption window annotations of pods and workloads. Requires a ValidatingWebhookConfiguration pointing at it. Defaults to false")
+	fs.StringVar(&webhookMode, "webhook-mode", string(controller.WebhookModeDeny), "What the webhook does with disruption window annotations the controller can't use: Deny rejects them, Warn admits them with a warning. Defaults to Deny")
+	fs.IntVar(&webhookPort, "webhook-port", webhook.DefaultPort, "Port serving the admission webhook over HTTPS")
+	fs.StringVar(&webhookCertDir, "webhook-cert-dir", "", "Directory with the certificate and key serving the webhook, e.g. from a mounted Secret. Changed files are picked up without a restart. Defaults to <tmp>/k8s-webhook-server/serving-certs")
+	fs.StringVar(&webhookCertName, "webhook-cert-name", "tls.crt", "File name of the webhook certificate in --webhook-cert-dir")
+	fs.StringVar(&webhookKeyName, "webhook-key-name", "tls.key", "File name of the webhook key in --webhook-cert-dir")
+	fs.StringVar(&configFile, "config", "", "Path to a ControllerConfig YAML file, e.g. from a mounted ConfigMap. Its values override the matching flags and changes are applied without a restart")
+}
+
 // initializes klog and prometheus metrics, then parses command-line flags.
 func initFlags() {
-	flag.BoolVar(&dryRun, "dry-run", true, "Whether or not to execute do-not-disrupt pod annotation removals. Defaults to true")
+	registerFlags(flag.CommandLine)
 	flag.Parse()
 	klog.Infoln("Parsed Flags:")
 	flag.Visit(func(f *flag.Flag) {
@@ -40,38 +126,200 @@ func initFlags() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "explain":
+			os.Exit(explain(os.Args[2:]))
+		case "validate":
+			os.Exit(validate(os.Args[2:]))
+		}
+	}
 	initFlags()
-	metrics.Register()
-	mgr, err := ctrlruntime.NewManager(clienthelpers.GetConfig(), ctrlruntime.Options{
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+	logger := klog.NewKlogr()
+	log.IntoContext(ctx, logger)
+	log.SetLogger(logger)
+
+	config := clienthelpers.GetConfig()
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		klog.Fatalf("Error creating discovery client: %v", err)
+	}
+	source, err := controller.NewEventSource(eventSource, discoveryClient)
+	if err != nil {
+		klog.Fatalf("Invalid --event-source: %v", err)
+	}
+	klog.Infof("Reading DisruptionBlocked events from %s", source.Name())
+	controllerConfig, configWatcher := loadControllerConfig(ctx)
+
+	metricsOptions := metricsserver.Options{
+		BindAddress:   metricsAddr,
+		SecureServing: metricsSecure,
+		CertDir:       metricsCertDir,
+		CertName:      metricsCertName,
+		KeyName:       metricsKeyName,
+	}
+	if metricsSecure {
+		metricsOptions.FilterProvider = filters.WithAuthenticationAndAuthorization
+	}
+	var webhookServer webhook.Server
+	var mode controller.WebhookMode
+	if enableWebhook {
+		if mode, err = controller.ParseWebhookMode(webhookMode); err != nil {
+			klog.Fatalf("Invalid --webhook-mode: %v", err)
+		}
+		webhookServer = webhook.NewServer(webhook.Options{
+			Port:     webhookPort,
+			CertDir:  webhookCertDir,
+			CertName: webhookCertName,
+			KeyName:  webhookKeyName,
+		})
+	}
+
+	mgr, err := ctrlruntime.NewManager(config, ctrlruntime.Options{
 		Cache: cache.Options{
-			SyncPeriod: &syncPeriod,
+			SyncPeriod: &controllerConfig.SyncPeriod.Duration,
 			ByObject: map[client.Object]cache.ByObject{
-				&corev1.Event{}: {
-					Field: fields.SelectorFromSet(fields.Set{
-						"involvedObject.kind": controller.DisruptionBlockedEventKind,
-						"reason":              controller.DisruptionBlockedEventReason,
-					}),
-				},
+				source.NewObject(): {Field: source.CacheSelector(controllerConfig.EventMatchers.CommonFields())},
 			},
 		},
-		NewCache: clienthelpers.NewCache,
-		Client:   opts,
+		NewCache:                clienthelpers.NewCache,
+		Client:                  opts,
+		Metrics:                 metricsOptions,
+		WebhookServer:           webhookServer,
+		HealthProbeBindAddress:  probeAddr,
+		LeaderElection:          leaderElect,
+		LeaderElectionNamespace: leaderElectionNS,
+		LeaderElectionID:        leaderElectionID,
+		// Standby replicas can take over right away instead of waiting for the lease to expire
+		LeaderElectionReleaseOnCancel: true,
+		LeaseDuration:                 &leaseDuration,
+		RenewDeadline:                 &renewDeadline,
+		RetryPeriod:                   &retryPeriod,
 	})
 	if err != nil {
 		klog.Fatalf("Error creating Controller Manager: %v", err)
 	}
 
-	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
-	defer cancel()
-	logger := klog.NewKlogr()
-	log.IntoContext(ctx, logger)
-	log.SetLogger(logger)
+	if err := mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		klog.Fatalf("unable to add health check: %v", err)
+	}
+	if err := mgr.AddReadyzCheck("informers", clienthelpers.InformersSynced(mgr.GetCache(), source.NewObject(), &corev1.Pod{})); err != nil {
+		klog.Fatalf("unable to add readiness check: %v", err)
+	}
+	if webhookServer != nil {
+		if err := mgr.AddReadyzCheck("webhook", webhookServer.StartedChecker()); err != nil {
+			klog.Fatalf("unable to add webhook readiness check: %v", err)
+		}
+	}
 
-	nController := &controller.DeprovisionController{Client: mgr.GetClient()}
-	if err := nController.Register(context.Background(), mgr); err != nil {
-		klog.Fatalf("unable to register controller: %v", err)
+	nController := newDeprovisionController(ctx, mgr.GetClient(), configWatcher)
+	nController.EventSource = source
+	nController.Tracker = &controller.UnblockTracker{Client: mgr.GetClient()}
+	nController.Recorder = mgr.GetEventRecorderFor("karpenter-deprovision-controller")
+	nController.DryRun = dryRun
+	metrics.Register(&controller.BlockedNodesCollector{DeprovisionController: nController})
+	nController.ApplyConfig(controllerConfig)
+	if configWatcher != nil {
+		configWatcher.Controller = nController
+		if err := mgr.Add(configWatcher); err != nil {
+			klog.Fatalf("unable to register configuration watcher: %v", err)
+		}
+	}
+	if reconcileEvents {
+		if err := nController.Register(context.Background(), mgr); err != nil {
+			klog.Fatalf("unable to register controller: %v", err)
+		}
+	}
+	if reconcileNodeClaims {
+		ncController := &controller.NodeClaimController{DeprovisionController: nController}
+		if err := ncController.Register(context.Background(), mgr); err != nil {
+			klog.Fatalf("unable to register nodeclaim controller: %v", err)
+		}
+	}
+	if enableWebhook {
+		// Served by every replica, not just the leader
+		windowWebhook := &controller.WindowWebhook{DeprovisionController: nController, Mode: mode}
+		if err := windowWebhook.Register(context.Background(), mgr); err != nil {
+			klog.Fatalf("unable to register webhook: %v", err)
+		}
+	}
+	if restoreAnnotations {
+		restoreController := &controller.RestoreController{DeprovisionController: nController}
+		if err := restoreController.Register(context.Background(), mgr); err != nil {
+			klog.Fatalf("unable to register restore controller: %v", err)
+		}
 	}
 	if err := mgr.Start(ctx); err != nil {
 		klog.Fatalf("unable to start manager: %v", err)
 	}
 }
+
+// loadControllerConfig builds the configuration from the flags and the --config file, which overrides them.
+func loadControllerConfig(ctx context.Context) (*controller.Config, *controller.ConfigWatcher) {
+	eventMatchers, err := controller.BuiltinEventMatchersFor(karpenterVersion)
+	if err != nil {
+		klog.Fatalf("Invalid --karpenter-version: %v", err)
+	}
+	if eventMatchersFile != "" {
+		if eventMatchers, err = controller.LoadEventMatchers(eventMatchersFile); err != nil {
+			klog.Fatalf("Invalid --event-matchers-file: %v", err)
+		}
+	}
+
+	controllerConfig := controller.DefaultConfig()
+	controllerConfig.EventMatchers = eventMatchers
+	controllerConfig.Annotations.Prefix = annotationPrefix
+	if deprecatedPrefixes != "" {
+		controllerConfig.Annotations.DeprecatedPrefixes = strings.Split(deprecatedPrefixes, ",")
+	}
+	controllerConfig.Concurrency = controller.ConcurrencySettings{
+		MaxUnblockedNodes:            maxUnblockedNodes,
+		MaxUnblockedNodesPerNodePool: maxUnblockedPerPool,
+	}
+	if controllerConfig.Events.Verbosity, err = controller.ParseEventVerbosity(eventVerbosity); err != nil {
+		klog.Fatalf("Invalid --event-verbosity: %v", err)
+	}
+	if controllerConfig.Windows.InvalidPolicy, err = controller.ParseInvalidWindowPolicy(invalidWindow); err != nil {
+		klog.Fatalf("Invalid --invalid-window-policy: %v", err)
+	}
+	controllerConfig.Windows.DefaultSchedule = defaultSchedule
+	if err := controllerConfig.Validate(); err != nil {
+		klog.Fatalf("Invalid flags: %v", err)
+	}
+	var configWatcher *controller.ConfigWatcher
+	if configFile != "" || blackoutFile != "" {
+		configWatcher = &controller.ConfigWatcher{Path: configFile, Base: controllerConfig, BlackoutPath: blackoutFile}
+	}
+	if configFile != "" {
+		if controllerConfig, err = configWatcher.Load(ctx); err != nil {
+			klog.Fatalf("Invalid --config: %v", err)
+		}
+	}
+	return controllerConfig, configWatcher
+}
+
+// newDeprovisionController sets up the parts of the controller that decide whether pods are unblocked, with the
+// blackout calendar read by configWatcher.
+func newDeprovisionController(ctx context.Context, c client.Client, configWatcher *controller.ConfigWatcher) *controller.DeprovisionController {
+	reasonPolicies, err := controller.ParseReasonPolicies(unblockReasons)
+	if err != nil {
+		klog.Fatalf("Invalid --unblock-reasons: %v", err)
+	}
+	nController := &controller.DeprovisionController{
+		Client:           c,
+		ReasonPolicies:   reasonPolicies,
+		MaxBlockDuration: maxBlockDuration,
+		EvictAfter:       evictAfter,
+	}
+	if blackoutFile != "" {
+		if nController.Blackouts, err = configWatcher.LoadBlackouts(ctx); err != nil {
+			klog.Fatalf("Invalid --blackout-file: %v", err)
+		}
+	}
+	if disruptionPolicies {
+		nController.Resolver = &controller.WindowResolver{Client: c}
+	}
+	return nController
+}
@@ -0,0 +1,60 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DisruptionPolicySpec supplies disruption window settings to the pods it selects.
+// Values set through pod annotations take precedence over the policy.
+type DisruptionPolicySpec struct {
+	// NamespaceSelector selects the namespaces whose pods this policy applies to.
+	// If omitted, pods in every namespace are selected.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// PodSelector selects the pods this policy applies to.
+	// If omitted, every pod in the selected namespaces is selected.
+	// +optional
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+	// Schedule is a standard cron expression marking the start of each disruption window.
+	// If omitted, the window is always active.
+	// +optional
+	Schedule string `json:"schedule,omitempty"`
+	// Duration is how long each disruption window stays open after the schedule hits.
+	// +kubebuilder:validation:Type="string"
+	// +kubebuilder:validation:Format="duration"
+	// +optional
+	Duration *metav1.Duration `json:"duration,omitempty"`
+	// Timezone is the IANA time zone the schedule is evaluated in, e.g. "Europe/Berlin". Defaults to UTC.
+	// +optional
+	Timezone string `json:"timezone,omitempty"`
+	// Enabled controls whether do-not-disrupt annotations may be removed from selected pods at all.
+	// +kubebuilder:default:=true
+	// +optional
+	Enabled *bool `json:"enabled,omitempty"`
+	// Weight decides which policy wins when several select the same pod, the highest weight wins.
+	// Ties are broken by policy name.
+	// +optional
+	Weight int32 `json:"weight,omitempty"`
+}
+
+// DisruptionPolicy scopes disruption window rules to pods by namespace and label selectors.
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=disruptionpolicies,scope=Cluster
+// +kubebuilder:printcolumn:name="Schedule",type="string",JSONPath=".spec.schedule"
+// +kubebuilder:printcolumn:name="Duration",type="string",JSONPath=".spec.duration"
+// +kubebuilder:printcolumn:name="Weight",type="integer",JSONPath=".spec.weight"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+type DisruptionPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec DisruptionPolicySpec `json:"spec,omitempty"`
+}
+
+// DisruptionPolicyList contains a list of DisruptionPolicy
+// +kubebuilder:object:root=true
+type DisruptionPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DisruptionPolicy `json:"items"`
+}
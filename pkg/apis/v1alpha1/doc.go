@@ -0,0 +1,6 @@
+// Package v1alpha1 contains the API types served by the Karpenter Deprovision Controller.
+// +kubebuilder:object:generate=true
+// +groupName=deprovision.jukie.dev
+package v1alpha1
+
+//go:generate go run sigs.k8s.io/controller-tools/cmd/controller-gen@v0.17.0 object paths=./... crd:crdVersions=v1 output:crd:dir=../../../configs/crds
@@ -0,0 +1,26 @@
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrlscheme "sigs.k8s.io/controller-runtime/pkg/scheme"
+)
+
+var (
+	// GroupVersion is the group and version used to register these objects.
+	GroupVersion = schema.GroupVersion{Group: "deprovision.jukie.dev", Version: "v1alpha1"}
+
+	// SchemeBuilder is used to add go types to the GroupVersionKind scheme.
+	SchemeBuilder = &ctrlscheme.Builder{GroupVersion: GroupVersion}
+
+	// AddToScheme adds the types in this group-version to the given scheme.
+	AddToScheme = SchemeBuilder.AddToScheme
+)
+
+// Registered with the client-go scheme the same way Karpenter's APIs are so the default clients can use them.
+func init() {
+	SchemeBuilder.Register(&DisruptionPolicy{}, &DisruptionPolicyList{})
+	if err := AddToScheme(scheme.Scheme); err != nil {
+		panic(err)
+	}
+}
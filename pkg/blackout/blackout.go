@@ -0,0 +1,157 @@
+// Package blackout loads blackout calendars during which do-not-disrupt annotations must never be removed,
+// regardless of any active disruption window.
+package blackout
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"sigs.k8s.io/yaml"
+)
+
+const dateLayout = "2006-01-02"
+
+// Calendar is a set of recurring blackout windows and fixed blackout periods.
+type Calendar struct {
+	Windows []Window `json:"windows,omitempty"`
+	Dates   []Period `json:"dates,omitempty"`
+}
+
+// Window is a recurring blackout that starts whenever Schedule hits and lasts for Duration.
+type Window struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`
+	Duration string `json:"duration"`
+	// Timezone is the IANA timezone the schedule is evaluated in, UTC when empty.
+	Timezone string `json:"timezone,omitempty"`
+
+	schedule cron.Schedule
+	duration time.Duration
+}
+
+// Period is a fixed blackout between Start and End.
+// Both accept RFC3339 timestamps or YYYY-MM-DD dates, a date-only End covers that whole day.
+type Period struct {
+	Name  string `json:"name"`
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// Timezone is the IANA timezone date-only values are interpreted in, UTC when empty.
+	Timezone string `json:"timezone,omitempty"`
+
+	start time.Time
+	end   time.Time
+}
+
+// Load reads a calendar from a YAML file, or an iCalendar file when the extension is .ics.
+// ConfigMaps are supported by mounting them as a volume.
+func Load(path string) (*Calendar, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading blackout calendar %s: %w", path, err)
+	}
+	return ParseFile(path, data)
+}
+
+// ParseFile parses the contents of the calendar file at path, as iCalendar when the extension is .ics and YAML otherwise.
+func ParseFile(path string, data []byte) (*Calendar, error) {
+	if strings.EqualFold(filepath.Ext(path), ".ics") {
+		return ParseICS(data)
+	}
+	return Parse(data)
+}
+
+// Parse reads a calendar from YAML and validates every entry.
+func Parse(data []byte) (*Calendar, error) {
+	calendar := &Calendar{}
+	if err := yaml.UnmarshalStrict(data, calendar); err != nil {
+		return nil, fmt.Errorf("failed parsing blackout calendar: %w", err)
+	}
+	for i := range calendar.Windows {
+		if err := calendar.Windows[i].parse(); err != nil {
+			return nil, err
+		}
+	}
+	for i := range calendar.Dates {
+		if err := calendar.Dates[i].parse(); err != nil {
+			return nil, err
+		}
+	}
+	return calendar, nil
+}
+
+// Active returns the name of a blackout covering t. A nil Calendar never has an active blackout.
+func (c *Calendar) Active(t time.Time) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	for _, p := range c.Dates {
+		if !t.Before(p.start) && t.Before(p.end) {
+			return p.Name, true
+		}
+	}
+	for _, w := range c.Windows {
+		// Walk back in time for the duration associated with the schedule and check if t is inside the window.
+		// Schedules that never hit, e.g. on February 30th, return the zero time and are never active.
+		if next := w.schedule.Next(t.Add(-w.duration)); !next.IsZero() && !next.After(t) {
+			return w.Name, true
+		}
+	}
+	return "", false
+}
+
+func (w *Window) parse() error {
+	if w.Timezone == "" {
+		w.Timezone = "UTC"
+	}
+	schedule, err := cron.ParseStandard(fmt.Sprintf("TZ=%s %s", w.Timezone, w.Schedule))
+	if err != nil {
+		return fmt.Errorf("invalid schedule for blackout window %q: %w", w.Name, err)
+	}
+	duration, err := time.ParseDuration(w.Duration)
+	if err != nil || duration <= 0 {
+		return fmt.Errorf("invalid duration %q for blackout window %q", w.Duration, w.Name)
+	}
+	w.schedule = schedule
+	w.duration = duration
+	return nil
+}
+
+func (p *Period) parse() error {
+	location := time.UTC
+	if p.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(p.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone for blackout period %q: %w", p.Name, err)
+		}
+	}
+	start, _, err := parseTime(p.Start, location)
+	if err != nil {
+		return fmt.Errorf("invalid start for blackout period %q: %w", p.Name, err)
+	}
+	end, dateOnly, err := parseTime(p.End, location)
+	if err != nil {
+		return fmt.Errorf("invalid end for blackout period %q: %w", p.Name, err)
+	}
+	if dateOnly {
+		end = end.AddDate(0, 0, 1)
+	}
+	if !end.After(start) {
+		return fmt.Errorf("blackout period %q ends before it starts", p.Name)
+	}
+	p.start = start
+	p.end = end
+	return nil
+}
+
+// parseTime accepts RFC3339 timestamps or plain dates, reporting whether the value was date-only.
+func parseTime(value string, location *time.Location) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation(dateLayout, value, location); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	return t, false, err
+}
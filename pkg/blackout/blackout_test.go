@@ -0,0 +1,181 @@
+package blackout_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/blackout"
+	"github.com/stretchr/testify/assert"
+)
+
+const testCalendar = `
+windows:
+  - name: friday-freeze
+    schedule: "0 12 * * 5"
+    duration: 60h
+    timezone: Europe/Berlin
+  - name: never
+    schedule: "0 0 30 2 *"
+    duration: 24h
+dates:
+  - name: year-end
+    start: 2026-12-20
+    end: 2027-01-02
+  - name: launch
+    start: 2026-10-01T08:00:00Z
+    end: 2026-10-01T20:00:00Z
+`
+
+const testICS = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Thanksgiving\r\n" +
+	"DTSTART;VALUE=DATE:20261126\r\n" +
+	"DTEND;VALUE=DATE:20261128\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Release\r\n" +
+	"  Day\r\n" +
+	"DTSTART;TZID=America/New_York:20261015T090000\r\n" +
+	"DTEND;TZID=America/New_York:20261015T170000\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"ACTION:DISPLAY\r\n" +
+	"SUMMARY:Reminder\r\n" +
+	"TRIGGER:-PT15M\r\n" +
+	"DTSTART:20261015T205000Z\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:Independence Day\r\n" +
+	"DTSTART;VALUE=DATE:20260704\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestCalendar_Active(t *testing.T) {
+	calendar, err := blackout.Parse([]byte(testCalendar))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		at         time.Time
+		wantName   string
+		wantActive bool
+	}{
+		{
+			name:       "Recurring window in its timezone",
+			at:         time.Date(2026, 10, 16, 11, 0, 0, 0, time.UTC),
+			wantName:   "friday-freeze",
+			wantActive: true,
+		},
+		{
+			name:       "Before recurring window",
+			at:         time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC),
+			wantActive: false,
+		},
+		{
+			name:       "Schedule that never fires",
+			at:         time.Date(2027, 3, 1, 12, 0, 0, 0, time.UTC),
+			wantActive: false,
+		},
+		{
+			name:       "Date-only end covers the whole day",
+			at:         time.Date(2027, 1, 2, 23, 0, 0, 0, time.UTC),
+			wantName:   "year-end",
+			wantActive: true,
+		},
+		{
+			name:       "After date range",
+			at:         time.Date(2027, 1, 4, 12, 0, 0, 0, time.UTC),
+			wantActive: false,
+		},
+		{
+			name:       "Timestamp range",
+			at:         time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+			wantName:   "launch",
+			wantActive: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, active := calendar.Active(tt.at)
+			assert.Equal(t, tt.wantActive, active)
+			assert.Equal(t, tt.wantName, name)
+		})
+	}
+
+	var nilCalendar *blackout.Calendar
+	_, active := nilCalendar.Active(time.Now())
+	assert.False(t, active)
+}
+
+func TestParse_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		calendar string
+	}{
+		{name: "Bad schedule", calendar: "windows:\n  - name: x\n    schedule: nope\n    duration: 1h\n"},
+		{name: "Bad duration", calendar: "windows:\n  - name: x\n    schedule: '0 0 * * *'\n    duration: soon\n"},
+		{name: "Unknown timezone", calendar: "windows:\n  - name: x\n    schedule: '0 0 * * *'\n    duration: 1h\n    timezone: Mars/Base\n"},
+		{name: "End before start", calendar: "dates:\n  - name: x\n    start: 2026-02-01\n    end: 2026-01-01\n"},
+		{name: "Unknown field", calendar: "holidays: []\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := blackout.Parse([]byte(tt.calendar))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoad_ICS(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "holidays.ics")
+	assert.NoError(t, os.WriteFile(path, []byte(testICS), 0o600))
+
+	calendar, err := blackout.Load(path)
+	assert.NoError(t, err)
+	assert.Len(t, calendar.Dates, 3)
+
+	tests := []struct {
+		name       string
+		at         time.Time
+		wantName   string
+		wantActive bool
+	}{
+		{name: "All-day event", at: time.Date(2026, 11, 27, 18, 0, 0, 0, time.UTC), wantName: "Thanksgiving", wantActive: true},
+		{name: "DTEND is exclusive", at: time.Date(2026, 11, 28, 0, 0, 0, 0, time.UTC), wantActive: false},
+		{name: "Folded summary with TZID", at: time.Date(2026, 10, 15, 20, 0, 0, 0, time.UTC), wantName: "Release Day", wantActive: true},
+		{name: "All-day event without DTEND", at: time.Date(2026, 7, 4, 12, 0, 0, 0, time.UTC), wantName: "Independence Day", wantActive: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, active := calendar.Active(tt.at)
+			assert.Equal(t, tt.wantActive, active)
+			assert.Equal(t, tt.wantName, name)
+		})
+	}
+}
+
+func TestParseICS_Invalid(t *testing.T) {
+	event := func(lines ...string) string {
+		return "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nSUMMARY:Freeze\r\n" + strings.Join(lines, "\r\n") + "\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+	}
+	tests := []struct {
+		name     string
+		calendar string
+	}{
+		{name: "Recurrence rule", calendar: event("DTSTART:20261016T090000Z", "DTEND:20261016T170000Z", "RRULE:FREQ=WEEKLY;BYDAY=FR")},
+		{name: "Recurrence dates", calendar: event("DTSTART:20261016T090000Z", "DTEND:20261016T170000Z", "RDATE:20261023T090000Z")},
+		{name: "Start only in alarm", calendar: event("BEGIN:VALARM", "DTSTART:20261016T090000Z", "END:VALARM")},
+		{name: "Missing DTEND", calendar: event("DTSTART:20261016T090000Z")},
+		{name: "Unexpected END", calendar: "BEGIN:VCALENDAR\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := blackout.ParseICS([]byte(tt.calendar))
+			assert.Error(t, err)
+		})
+	}
+}
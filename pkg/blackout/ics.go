@@ -0,0 +1,150 @@
+package blackout
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	icsDateLayout     = "20060102"
+	icsDateTimeLayout = "20060102T150405"
+)
+
+// ParseICS reads every VEVENT of an iCalendar file as a blackout period.
+// Only explicit DTSTART/DTEND events are supported. Recurring events are rejected rather than only blocking their
+// first occurrence, recurring blackouts belong in the windows of a YAML calendar. Properties of components nested in an
+// event, e.g. the SUMMARY of a VALARM, are ignored.
+func ParseICS(data []byte) (*Calendar, error) {
+	calendar := &Calendar{}
+	var event map[string]icsProperty
+	// nested counts the components open inside the current event
+	nested := 0
+	for i, line := range unfoldICS(data) {
+		name, prop, err := parseICSLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid iCalendar line %d: %w", i+1, err)
+		}
+		switch {
+		case event == nil && name == "BEGIN" && prop.value == "VEVENT":
+			event = map[string]icsProperty{}
+		case event == nil && name == "END" && prop.value == "VEVENT":
+			return nil, fmt.Errorf("unexpected END:VEVENT on line %d", i+1)
+		case event == nil:
+		case name == "BEGIN":
+			nested++
+		case name == "END" && nested > 0:
+			nested--
+		case name == "END" && prop.value == "VEVENT":
+			period, err := periodFromICS(event)
+			if err != nil {
+				return nil, err
+			}
+			calendar.Dates = append(calendar.Dates, period)
+			event = nil
+		case nested == 0:
+			event[name] = prop
+		}
+	}
+	return calendar, nil
+}
+
+type icsProperty struct {
+	params map[string]string
+	value  string
+}
+
+// unfoldICS joins continuation lines, which start with a space or tab, onto the previous line.
+func unfoldICS(data []byte) []string {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// parseICSLine splits "NAME;PARAM=VALUE:value" into its parts.
+func parseICSLine(line string) (string, icsProperty, error) {
+	head, value, ok := strings.Cut(line, ":")
+	if !ok {
+		return "", icsProperty{}, fmt.Errorf("missing ':' in %q", line)
+	}
+	parts := strings.Split(head, ";")
+	prop := icsProperty{params: map[string]string{}, value: value}
+	for _, param := range parts[1:] {
+		k, v, _ := strings.Cut(param, "=")
+		prop.params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+	}
+	return strings.ToUpper(parts[0]), prop, nil
+}
+
+func periodFromICS(event map[string]icsProperty) (Period, error) {
+	name := event["SUMMARY"].value
+	for _, recurrence := range []string{"RRULE", "RDATE"} {
+		if _, ok := event[recurrence]; ok {
+			return Period{}, fmt.Errorf("event %q recurs with %s, which isn't supported, use a blackout window in a YAML calendar instead", name, recurrence)
+		}
+	}
+	dtstart, ok := event["DTSTART"]
+	if !ok {
+		return Period{}, fmt.Errorf("event %q has no DTSTART", name)
+	}
+	start, dateOnly, err := parseICSTime(dtstart)
+	if err != nil {
+		return Period{}, fmt.Errorf("invalid DTSTART for event %q: %w", name, err)
+	}
+
+	var end time.Time
+	if dtend, ok := event["DTEND"]; ok {
+		if end, _, err = parseICSTime(dtend); err != nil {
+			return Period{}, fmt.Errorf("invalid DTEND for event %q: %w", name, err)
+		}
+	} else if dateOnly {
+		// All-day events without DTEND last a single day
+		end = start.AddDate(0, 0, 1)
+	} else {
+		return Period{}, fmt.Errorf("event %q has no DTEND", name)
+	}
+	if !end.After(start) {
+		return Period{}, fmt.Errorf("event %q ends before it starts", name)
+	}
+	return Period{
+		Name:  name,
+		Start: start.Format(time.RFC3339),
+		End:   end.Format(time.RFC3339),
+		start: start,
+		end:   end,
+	}, nil
+}
+
+// parseICSTime handles DATE values, UTC DATE-TIME values and DATE-TIME values with a TZID.
+// Floating times without a TZID are treated as UTC.
+func parseICSTime(prop icsProperty) (time.Time, bool, error) {
+	location := time.UTC
+	if tzid := prop.params["TZID"]; tzid != "" {
+		var err error
+		if location, err = time.LoadLocation(tzid); err != nil {
+			return time.Time{}, false, err
+		}
+	}
+	if prop.params["VALUE"] == "DATE" || len(prop.value) == len(icsDateLayout) {
+		t, err := time.ParseInLocation(icsDateLayout, prop.value, location)
+		return t, true, err
+	}
+	if strings.HasSuffix(prop.value, "Z") {
+		t, err := time.Parse(icsDateTimeLayout+"Z", prop.value)
+		return t, false, err
+	}
+	t, err := time.ParseInLocation(icsDateTimeLayout, prop.value, location)
+	return t, false, err
+}
@@ -3,6 +3,7 @@ package clienthelpers
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 
@@ -11,6 +12,8 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
 )
 
 // GetConfig attempts to create an in-cluster configuration and falls back to using KUBECONFIG from the environment if in-cluster config is not available.
@@ -27,12 +30,30 @@ func GetConfig() *rest.Config {
 	return config
 }
 
+// GetKubeconfig loads a kubeconfig the way kubectl does, from path when set or else KUBECONFIG and ~/.kube/config.
+// An empty context uses the current context.
+func GetKubeconfig(path, context string) (*rest.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = path
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: context}).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed loading kubeconfig: %w", err)
+	}
+	return config, nil
+}
+
 // PodIdxFunc is used for listing pods by node
 var PodIdxFunc client.IndexerFunc = func(o client.Object) []string {
 	pod := o.(*corev1.Pod)
 	return []string{pod.Spec.NodeName}
 }
 
+// NodeClaimIdxFunc is used for looking up the NodeClaim backing a node
+var NodeClaimIdxFunc client.IndexerFunc = func(o client.Object) []string {
+	nc := o.(*karpv1.NodeClaim)
+	return []string{nc.Status.NodeName}
+}
+
 // NewCache sets up a client cache with a custom field indexer for pods
 func NewCache(config *rest.Config, options cache.Options) (cache.Cache, error) {
 	clientCache, err := cache.New(config, options)
@@ -44,5 +65,26 @@ func NewCache(config *rest.Config, options cache.Options) (cache.Cache, error) {
 	if err = clientCache.IndexField(context.TODO(), &corev1.Pod{}, "spec.nodeName", PodIdxFunc); err != nil {
 		return nil, fmt.Errorf("Issue building Pod indexer: %v", err)
 	}
+
+	// Needed for looking up NodeClaims by node
+	if err = clientCache.IndexField(context.TODO(), &karpv1.NodeClaim{}, "status.nodeName", NodeClaimIdxFunc); err != nil {
+		return nil, fmt.Errorf("Issue building NodeClaim indexer: %v", err)
+	}
 	return clientCache, nil
 }
+
+// InformersSynced is a readiness check passing once the cache's informers for every given object type have synced
+func InformersSynced(c cache.Cache, objs ...client.Object) healthz.Checker {
+	return func(req *http.Request) error {
+		for _, obj := range objs {
+			informer, err := c.GetInformer(req.Context(), obj, cache.BlockUntilSynced(false))
+			if err != nil {
+				return fmt.Errorf("failed getting informer for %T: %w", obj, err)
+			}
+			if !informer.HasSynced() {
+				return fmt.Errorf("informer for %T has not synced", obj)
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"fmt"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// DefaultAnnotationPrefix is the domain of the annotation keys unless configured otherwise
+	DefaultAnnotationPrefix = "k8s.adsrvr.net"
+
+	DisruptionWindowSchedName    = "disruption-window-schedule"
+	DisruptionWindowDurationName = "disruption-window-duration"
+	DisruptionWindowTimezoneName = "disruption-window-timezone"
+	OriginalDoNotDisruptName     = "original-do-not-disrupt"
+	MaxBlockDurationName         = "max-block-duration"
+)
+
+// AnnotationSettings configures the pod annotation keys. Each key is its name under Prefix unless set explicitly.
+type AnnotationSettings struct {
+	Prefix string `json:"prefix"`
+	// DeprecatedPrefixes are still read during a migration to a new prefix, Prefix wins when a pod has both.
+	// Reads from deprecated keys are counted in deprecated_annotations_total.
+	DeprecatedPrefixes []string `json:"deprecatedPrefixes,omitempty"`
+
+	Schedule             string `json:"schedule,omitempty"`
+	Duration             string `json:"duration,omitempty"`
+	Timezone             string `json:"timezone,omitempty"`
+	OriginalDoNotDisrupt string `json:"originalDoNotDisrupt,omitempty"`
+	MaxBlockDuration     string `json:"maxBlockDuration,omitempty"`
+}
+
+// DefaultAnnotationSettings uses the k8s.adsrvr.net keys the controller has always used.
+func DefaultAnnotationSettings() AnnotationSettings {
+	return AnnotationSettings{Prefix: DefaultAnnotationPrefix}
+}
+
+// AnnotationKey is an annotation along with the deprecated keys it's still read from.
+type AnnotationKey struct {
+	Name       string
+	Deprecated []string
+}
+
+// Get returns the value of the first key the annotations contain, checking Name before the deprecated keys.
+func (k AnnotationKey) Get(annotations map[string]string) (value, key string, ok bool) {
+	if value, ok := annotations[k.Name]; ok {
+		return value, k.Name, true
+	}
+	for _, key := range k.Deprecated {
+		if value, ok := annotations[key]; ok {
+			return value, key, true
+		}
+	}
+	return "", "", false
+}
+
+// Has reports whether the annotations contain any of the keys.
+func (k AnnotationKey) Has(annotations map[string]string) bool {
+	_, _, ok := k.Get(annotations)
+	return ok
+}
+
+// AnnotationKeys are the pod annotations the controller reads and writes.
+type AnnotationKeys struct {
+	Schedule             AnnotationKey
+	Duration             AnnotationKey
+	Timezone             AnnotationKey
+	OriginalDoNotDisrupt AnnotationKey
+	MaxBlockDuration     AnnotationKey
+}
+
+// DefaultAnnotationKeys are the annotation keys used when no configuration overrides them.
+var DefaultAnnotationKeys = DefaultAnnotationSettings().Keys()
+
+// Keys resolves the annotation keys.
+func (s AnnotationSettings) Keys() AnnotationKeys {
+	return AnnotationKeys{
+		Schedule:             s.key(s.Schedule, DisruptionWindowSchedName),
+		Duration:             s.key(s.Duration, DisruptionWindowDurationName),
+		Timezone:             s.key(s.Timezone, DisruptionWindowTimezoneName),
+		OriginalDoNotDisrupt: s.key(s.OriginalDoNotDisrupt, OriginalDoNotDisruptName),
+		MaxBlockDuration:     s.key(s.MaxBlockDuration, MaxBlockDurationName),
+	}
+}
+
+func (s AnnotationSettings) key(override, name string) AnnotationKey {
+	key := AnnotationKey{Name: override}
+	if key.Name == "" {
+		key.Name = s.Prefix + "/" + name
+	}
+	for _, prefix := range s.DeprecatedPrefixes {
+		if deprecated := prefix + "/" + name; deprecated != key.Name {
+			key.Deprecated = append(key.Deprecated, deprecated)
+		}
+	}
+	return key
+}
+
+// Validate checks that the prefixes are DNS subdomains and every resolved key is a valid, distinct annotation key.
+func (s AnnotationSettings) Validate() error {
+	for _, prefix := range append([]string{s.Prefix}, s.DeprecatedPrefixes...) {
+		if errs := validation.IsDNS1123Subdomain(prefix); len(errs) > 0 {
+			return fmt.Errorf("invalid annotation prefix %q: %v", prefix, errs)
+		}
+	}
+	keys := s.Keys()
+	names := map[string]string{}
+	for field, key := range map[string]AnnotationKey{
+		"schedule":             keys.Schedule,
+		"duration":             keys.Duration,
+		"timezone":             keys.Timezone,
+		"originalDoNotDisrupt": keys.OriginalDoNotDisrupt,
+		"maxBlockDuration":     keys.MaxBlockDuration,
+	} {
+		for _, name := range append([]string{key.Name}, key.Deprecated...) {
+			if errs := validation.IsQualifiedName(name); len(errs) > 0 {
+				return fmt.Errorf("invalid annotation key %q for annotations.%s: %v", name, field, errs)
+			}
+		}
+		if other, ok := names[key.Name]; ok {
+			return fmt.Errorf("annotations.%s and annotations.%s both use %q", field, other, key.Name)
+		}
+		names[key.Name] = field
+	}
+	return nil
+}
+
+// readAnnotation returns the pod's value for the key, counting reads from deprecated keys.
+func readAnnotation(pod *corev1.Pod, key AnnotationKey) (string, bool) {
+	value, found, ok := key.Get(pod.Annotations)
+	if ok && found != key.Name {
+		metrics.DeprecatedAnnotationCounter.With(prometheus.Labels{
+			metrics.AnnotationLabel: found,
+			metrics.NamespaceLabel:  pod.Namespace,
+		}).Inc()
+	}
+	return value, ok
+}
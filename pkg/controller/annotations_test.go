@@ -0,0 +1,103 @@
+package controller_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/controller"
+	"github.com/jukie/karpenter-deprovision-controller/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+func TestAnnotationSettings_Keys(t *testing.T) {
+	keys := controller.DefaultAnnotationSettings().Keys()
+	assert.Equal(t, controller.DisruptionWindowSchedKey, keys.Schedule.Name)
+	assert.Equal(t, controller.OriginalDoNotDisruptKey, keys.OriginalDoNotDisrupt.Name)
+	assert.Empty(t, keys.Schedule.Deprecated)
+
+	keys = controller.AnnotationSettings{
+		Prefix:             "example.com",
+		DeprecatedPrefixes: []string{controller.DefaultAnnotationPrefix},
+		Timezone:           "example.com/tz",
+	}.Keys()
+	assert.Equal(t, "example.com/disruption-window-schedule", keys.Schedule.Name)
+	assert.Equal(t, []string{controller.DisruptionWindowSchedKey}, keys.Schedule.Deprecated)
+	assert.Equal(t, "example.com/tz", keys.Timezone.Name)
+	assert.Equal(t, []string{controller.DisruptionWindowTimezoneKey}, keys.Timezone.Deprecated)
+}
+
+func TestAnnotationSettings_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings controller.AnnotationSettings
+		wantErr  bool
+	}{
+		{name: "Defaults", settings: controller.DefaultAnnotationSettings()},
+		{name: "Deprecated prefix", settings: controller.AnnotationSettings{Prefix: "example.com", DeprecatedPrefixes: []string{controller.DefaultAnnotationPrefix}}},
+		{name: "Missing prefix", settings: controller.AnnotationSettings{}, wantErr: true},
+		{name: "Invalid prefix", settings: controller.AnnotationSettings{Prefix: "Example_com"}, wantErr: true},
+		{name: "Invalid deprecated prefix", settings: controller.AnnotationSettings{Prefix: "example.com", DeprecatedPrefixes: []string{"not a prefix"}}, wantErr: true},
+		{name: "Invalid key", settings: controller.AnnotationSettings{Prefix: "example.com", Schedule: "not a key"}, wantErr: true},
+		{name: "Duplicate key", settings: controller.AnnotationSettings{Prefix: "example.com", Schedule: "example.com/window", Duration: "example.com/window"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.settings.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestAnnotationKey_Get(t *testing.T) {
+	key := controller.AnnotationKey{Name: "example.com/window", Deprecated: []string{"old.example.com/window"}}
+
+	value, found, ok := key.Get(map[string]string{"old.example.com/window": "old", "example.com/window": "new"})
+	assert.True(t, ok)
+	assert.Equal(t, "new", value)
+	assert.Equal(t, "example.com/window", found)
+
+	value, found, ok = key.Get(map[string]string{"old.example.com/window": "old"})
+	assert.True(t, ok)
+	assert.Equal(t, "old", value)
+	assert.Equal(t, "old.example.com/window", found)
+
+	assert.False(t, key.Has(map[string]string{"other.example.com/window": "other"}))
+}
+
+func TestHandleBlockingPods_DeprecatedAnnotations(t *testing.T) {
+	inactiveSchedule := fmt.Sprintf("%d %d * * *", time.Now().UTC().Minute(), time.Now().Add(-4*time.Hour).UTC().Hour())
+	pod := setupTestPod("legacy-keys", "testing", "test-node", map[string]string{
+		karpv1.DoNotDisruptAnnotationKey:       "true",
+		controller.DisruptionWindowSchedKey:    inactiveSchedule,
+		controller.DisruptionWindowDurationKey: "3h",
+	})
+	config := controller.DefaultConfig()
+	config.Annotations = controller.AnnotationSettings{Prefix: "example.com", DeprecatedPrefixes: []string{controller.DefaultAnnotationPrefix}}
+
+	deprovisionController := &controller.DeprovisionController{Client: fake.NewClientBuilder().WithRuntimeObjects(pod).Build()}
+	deprovisionController.ApplyConfig(config)
+	deprovisionController.HandleBlockingPods(context.TODO(), []corev1.Pod{*pod}, controller.BlockedNode{Name: "test-node", Reason: controller.ReasonExpired})
+
+	updatedPod := &corev1.Pod{}
+	assert.NoError(t, deprovisionController.Client.Get(context.TODO(), types.NamespacedName{Name: pod.Name, Namespace: pod.Namespace}, updatedPod))
+	assert.Equal(t, "true", updatedPod.Annotations[karpv1.DoNotDisruptAnnotationKey], "Expected the deprecated schedule to keep the pod blocked")
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.DeprecatedAnnotationCounter.With(prometheus.Labels{
+		metrics.AnnotationLabel: controller.DisruptionWindowSchedKey,
+		metrics.NamespaceLabel:  "testing",
+	})))
+}
@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+// budgetRequeueInterval is how often a node held back by its NodePool's disruption budgets is rechecked
+const budgetRequeueInterval = time.Minute
+
+// nodePoolAllowsDisruption evaluates the disruption budgets of the NodePool owning the node for its disruption reason.
+// Karpenter v1 doesn't apply budgets to expiration, so expired nodes are only held to budgets that list no reasons,
+// matching how budgets applied to expiration before v1. Blocked nodes have no Karpenter reason either and are treated
+// the same way. Nodes that can't be matched to a NodePool are allowed.
+func (c *DeprovisionController) nodePoolAllowsDisruption(ctx context.Context, node BlockedNode, clk clock.Clock) (bool, error) {
+	nodePoolName := node.NodePool()
+	// Karpenter is already disrupting NodeClaims that are being deleted so its budget has been accounted for
+	if nodePoolName == "" || node.NodeClaim.DeletionTimestamp != nil {
+		return true, nil
+	}
+	nodePool := &karpv1.NodePool{}
+	if err := c.Client.Get(ctx, types.NamespacedName{Name: nodePoolName}, nodePool); err != nil {
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, fmt.Errorf("failed getting nodepool %s: %w", nodePoolName, err)
+	}
+
+	var ncList karpv1.NodeClaimList
+	if err := c.Client.List(ctx, &ncList, client.MatchingLabels{karpv1.NodePoolLabelKey: nodePoolName}); err != nil {
+		return false, fmt.Errorf("failed getting nodeclaims for nodepool %s: %w", nodePoolName, err)
+	}
+	disrupting := 0
+	for _, nc := range ncList.Items {
+		if nc.DeletionTimestamp != nil {
+			disrupting++
+		}
+	}
+
+	allowed, err := allowedDisruptions(ctx, nodePool, node.Reason, clk, len(ncList.Items))
+	if err != nil {
+		return false, fmt.Errorf("invalid disruption budgets on nodepool %s: %w", nodePoolName, err)
+	}
+	return allowed-disrupting > 0, nil
+}
+
+// allowedDisruptions returns the most restrictive active budget for the reason.
+// GetAllowedDisruptionsByReason only knows Karpenter's own reasons, so the rest fall back to budgets without reasons.
+func allowedDisruptions(ctx context.Context, nodePool *karpv1.NodePool, reason DisruptionReason, clk clock.Clock, numNodes int) (int, error) {
+	if reason != ReasonExpired && reason != ReasonBlocked {
+		byReason, err := nodePool.GetAllowedDisruptionsByReason(ctx, clk, numNodes)
+		if err != nil {
+			return 0, err
+		}
+		return byReason[karpv1.DisruptionReason(reason)], nil
+	}
+
+	allowed := math.MaxInt32
+	for _, budget := range nodePool.Spec.Disruption.Budgets {
+		if budget.Reasons != nil {
+			continue
+		}
+		val, err := budget.GetAllowedDisruptions(clk, numNodes)
+		if err != nil {
+			return 0, err
+		}
+		allowed = min(allowed, val)
+	}
+	return allowed, nil
+}
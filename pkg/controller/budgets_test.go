@@ -0,0 +1,154 @@
+package controller_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/controller"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+func setupTestNodePool(budgets ...karpv1.Budget) *karpv1.NodePool {
+	return &karpv1.NodePool{
+		ObjectMeta: metav1.ObjectMeta{Name: "default"},
+		Spec: karpv1.NodePoolSpec{
+			Disruption: karpv1.Disruption{Budgets: budgets},
+		},
+	}
+}
+
+func setupPoolNodeClaim(name string, deleting bool, conditions ...string) *karpv1.NodeClaim {
+	nc := setupTestNodeClaim(name, time.Hour, "2h", conditions...)
+	nc.Name = name
+	nc.Labels = map[string]string{karpv1.NodePoolLabelKey: "default"}
+	if deleting {
+		nc.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+		nc.Finalizers = []string{karpv1.TerminationFinalizer}
+	}
+	return nc
+}
+
+func TestHandleBlockingPods_NodePoolBudgets(t *testing.T) {
+	activeSchedule := fmt.Sprintf("%d %d * * *", time.Now().UTC().Minute(), time.Now().UTC().Hour())
+	inactiveSchedule := fmt.Sprintf("%d %d * * *", time.Now().UTC().Minute(), time.Now().Add(-4*time.Hour).UTC().Hour())
+
+	tests := []struct {
+		name          string
+		nodePool      *karpv1.NodePool
+		nodeClaim     *karpv1.NodeClaim
+		reason        controller.DisruptionReason
+		others        []runtime.Object
+		expectRemoved bool
+	}{
+		{
+			name:          "No budgets",
+			nodePool:      setupTestNodePool(),
+			nodeClaim:     setupPoolNodeClaim("test-node", false, karpv1.ConditionTypeDrifted),
+			reason:        controller.ReasonDrifted,
+			expectRemoved: true,
+		},
+		{
+			name:          "Drift budget of zero",
+			nodePool:      setupTestNodePool(karpv1.Budget{Nodes: "0", Reasons: []karpv1.DisruptionReason{karpv1.DisruptionReasonDrifted}}),
+			nodeClaim:     setupPoolNodeClaim("test-node", false, karpv1.ConditionTypeDrifted),
+			reason:        controller.ReasonDrifted,
+			expectRemoved: false,
+		},
+		{
+			name:          "Budget for another reason",
+			nodePool:      setupTestNodePool(karpv1.Budget{Nodes: "0", Reasons: []karpv1.DisruptionReason{karpv1.DisruptionReasonUnderutilized}}),
+			nodeClaim:     setupPoolNodeClaim("test-node", false, karpv1.ConditionTypeDrifted),
+			reason:        controller.ReasonDrifted,
+			expectRemoved: true,
+		},
+		{
+			name:          "Budget used up by nodes already disrupting",
+			nodePool:      setupTestNodePool(karpv1.Budget{Nodes: "1"}),
+			nodeClaim:     setupPoolNodeClaim("test-node", false, karpv1.ConditionTypeDrifted),
+			reason:        controller.ReasonDrifted,
+			others:        []runtime.Object{setupPoolNodeClaim("other-node", true)},
+			expectRemoved: false,
+		},
+		{
+			name:          "Scheduled budget is active",
+			nodePool:      setupTestNodePool(karpv1.Budget{Nodes: "0", Schedule: ptr.To(activeSchedule), Duration: &metav1.Duration{Duration: time.Hour}}),
+			nodeClaim:     setupPoolNodeClaim("test-node", false, karpv1.ConditionTypeDrifted),
+			reason:        controller.ReasonDrifted,
+			expectRemoved: false,
+		},
+		{
+			name:          "Scheduled budget is inactive",
+			nodePool:      setupTestNodePool(karpv1.Budget{Nodes: "0", Schedule: ptr.To(inactiveSchedule), Duration: &metav1.Duration{Duration: time.Hour}}),
+			nodeClaim:     setupPoolNodeClaim("test-node", false, karpv1.ConditionTypeDrifted),
+			reason:        controller.ReasonDrifted,
+			expectRemoved: true,
+		},
+		{
+			name:          "Expiration honours budgets without reasons",
+			nodePool:      setupTestNodePool(karpv1.Budget{Nodes: "0"}),
+			nodeClaim:     setupPoolNodeClaim("test-node", false, controller.ConditionTypeExpired),
+			reason:        controller.ReasonExpired,
+			expectRemoved: false,
+		},
+		{
+			name:          "Expiration ignores reason specific budgets",
+			nodePool:      setupTestNodePool(karpv1.Budget{Nodes: "0", Reasons: []karpv1.DisruptionReason{karpv1.DisruptionReasonDrifted}}),
+			nodeClaim:     setupPoolNodeClaim("test-node", false, controller.ConditionTypeExpired),
+			reason:        controller.ReasonExpired,
+			expectRemoved: true,
+		},
+		{
+			name:          "Blocked nodes honour budgets without reasons",
+			nodePool:      setupTestNodePool(karpv1.Budget{Nodes: "0"}),
+			nodeClaim:     setupPoolNodeClaim("test-node", false),
+			reason:        controller.ReasonBlocked,
+			expectRemoved: false,
+		},
+		{
+			name:          "Blocked nodes ignore reason specific budgets",
+			nodePool:      setupTestNodePool(karpv1.Budget{Nodes: "0", Reasons: []karpv1.DisruptionReason{karpv1.DisruptionReasonDrifted}}),
+			nodeClaim:     setupPoolNodeClaim("test-node", false),
+			reason:        controller.ReasonBlocked,
+			expectRemoved: true,
+		},
+		{
+			name:          "NodeClaim already being deleted",
+			nodePool:      setupTestNodePool(karpv1.Budget{Nodes: "0"}),
+			nodeClaim:     setupPoolNodeClaim("test-node", true, controller.ConditionTypeExpired),
+			reason:        controller.ReasonExpired,
+			expectRemoved: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := setupTestPod("blocking-no-sched", "testing", "test-node", map[string]string{karpv1.DoNotDisruptAnnotationKey: "true"})
+			reasonPolicies, err := controller.ParseReasonPolicies("Expired,Drifted,Blocked")
+			assert.NoError(t, err)
+			deprovisionController := &controller.DeprovisionController{
+				Client:         fake.NewClientBuilder().WithRuntimeObjects(append(tt.others, pod, tt.nodePool, tt.nodeClaim)...).Build(),
+				ReasonPolicies: reasonPolicies,
+			}
+			result := deprovisionController.HandleBlockingPods(context.TODO(), []corev1.Pod{*pod}, controller.BlockedNode{Name: "test-node", Reason: tt.reason, NodeClaim: tt.nodeClaim})
+
+			updatedPod := &corev1.Pod{}
+			assert.NoError(t, deprovisionController.Client.Get(context.TODO(), client.ObjectKeyFromObject(pod), updatedPod))
+			if tt.expectRemoved {
+				assert.NotContains(t, updatedPod.Annotations, karpv1.DoNotDisruptAnnotationKey, "Expected annotation to be removed")
+			} else {
+				assert.Equal(t, "true", updatedPod.Annotations[karpv1.DoNotDisruptAnnotationKey], "Expected annotation to be unchanged")
+				assert.Greater(t, result.RequeueAfter, time.Duration(0))
+			}
+		})
+	}
+}
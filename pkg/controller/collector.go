@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+// collectTimeout bounds how long a scrape waits for the cache, e.g. while it's still syncing on startup.
+const collectTimeout = 5 * time.Second
+
+// BlockedNodesCollector reports nodes being disrupted for a reason configured for unblocking that still have
+// pods with a do-not-disrupt annotation. The gauges are computed from the cache on every scrape so they never
+// go stale when nodes disappear.
+type BlockedNodesCollector struct {
+	*DeprovisionController
+}
+
+func (c *BlockedNodesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- metrics.BlockedNodesDesc
+	ch <- metrics.BlockingPodsDesc
+}
+
+func (c *BlockedNodesCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+	nodes, pods, err := c.blockedByNodePool(ctx, time.Now())
+	if err != nil {
+		log.Log.Error(err, "Failed collecting blocked nodes")
+		return
+	}
+	for nodePool, count := range nodes {
+		ch <- prometheus.MustNewConstMetric(metrics.BlockedNodesDesc, prometheus.GaugeValue, float64(count), nodePool)
+	}
+	for nodePool, count := range pods {
+		ch <- prometheus.MustNewConstMetric(metrics.BlockingPodsDesc, prometheus.GaugeValue, float64(count), nodePool)
+	}
+}
+
+// blockedByNodePool counts blocked nodes and their blocking pods per NodePool.
+func (c *BlockedNodesCollector) blockedByNodePool(ctx context.Context, now time.Time) (map[string]int, map[string]int, error) {
+	var ncList karpv1.NodeClaimList
+	if err := c.Client.List(ctx, &ncList); err != nil {
+		return nil, nil, fmt.Errorf("failed getting nodeclaims from cache: %w", err)
+	}
+	nodes, pods := map[string]int{}, map[string]int{}
+	for i := range ncList.Items {
+		nc := &ncList.Items[i]
+		if nc.Status.NodeName == "" {
+			continue
+		}
+		var podList corev1.PodList
+		if err := c.Client.List(ctx, &podList, client.MatchingFields{"spec.nodeName": nc.Status.NodeName}); err != nil {
+			return nil, nil, fmt.Errorf("failed getting pods from cache: %w", err)
+		}
+		reason, ok := DisruptionReasonFor(nc, podList.Items, now)
+		if !ok || !c.reasonPolicy(reason).AllowUnblock {
+			continue
+		}
+		blocking := 0
+		for _, pod := range podList.Items {
+			if pod.Annotations[karpv1.DoNotDisruptAnnotationKey] != "" {
+				blocking++
+			}
+		}
+		if blocking == 0 {
+			continue
+		}
+		nodePool := BlockedNode{NodeClaim: nc}.NodePool()
+		nodes[nodePool]++
+		pods[nodePool] += blocking
+	}
+	return nodes, pods, nil
+}
@@ -0,0 +1,90 @@
+package controller_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/clienthelpers"
+	"github.com/jukie/karpenter-deprovision-controller/pkg/controller"
+	"github.com/jukie/karpenter-deprovision-controller/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+// setupExpiredNodeClaim returns a NodeClaim in the given NodePool that expired an hour ago
+func setupExpiredNodeClaim(name, nodePool string) *karpv1.NodeClaim {
+	nc := setupTestNodeClaim(name, 3*time.Hour, "2h")
+	nc.Name = name
+	nc.Labels = map[string]string{karpv1.NodePoolLabelKey: nodePool}
+	return nc
+}
+
+func TestBlockedNodesCollector(t *testing.T) {
+	consolidatable := setupPoolNodeClaim("consolidatable", false, karpv1.ConditionTypeConsolidatable)
+	consolidatable.Spec.ExpireAfter = karpv1.MustParseNillableDuration("Never")
+	consolidatable.Status.NodeName = "consolidatable"
+
+	objList := []runtime.Object{
+		setupExpiredNodeClaim("expired", "default"),
+		setupExpiredNodeClaim("unblocked", "gpu"),
+		consolidatable,
+		setupTestPod("blocking-a", "testing", "expired", map[string]string{karpv1.DoNotDisruptAnnotationKey: "true"}),
+		setupTestPod("blocking-b", "testing", "expired", map[string]string{karpv1.DoNotDisruptAnnotationKey: "true"}),
+		setupTestPod("not-blocking", "testing", "expired", nil),
+		setupTestPod("unblocked", "testing", "unblocked", map[string]string{controller.OriginalDoNotDisruptKey: "true"}),
+		setupTestPod("consolidation-blocking", "testing", "consolidatable", map[string]string{karpv1.DoNotDisruptAnnotationKey: "true"}),
+	}
+	fakeClient := fake.NewClientBuilder().
+		WithRuntimeObjects(objList...).
+		WithIndex(&corev1.Pod{}, "spec.nodeName", clienthelpers.PodIdxFunc).
+		Build()
+	collector := &controller.BlockedNodesCollector{DeprovisionController: &controller.DeprovisionController{Client: fakeClient}}
+
+	expected := `
+# HELP karpenter_disruption_controller_blocked_nodes Number of nodes being disrupted that still have pods with a do-not-disrupt annotation. Labeled by NodePool.
+# TYPE karpenter_disruption_controller_blocked_nodes gauge
+karpenter_disruption_controller_blocked_nodes{nodepool="default"} 1
+# HELP karpenter_disruption_controller_blocking_pods Number of pods with a do-not-disrupt annotation on nodes being disrupted. Labeled by NodePool.
+# TYPE karpenter_disruption_controller_blocking_pods gauge
+karpenter_disruption_controller_blocking_pods{nodepool="default"} 2
+`
+	assert.NoError(t, testutil.CollectAndCompare(collector, strings.NewReader(expected)))
+}
+
+func TestHandleBlockingPods_Metrics(t *testing.T) {
+	nc := setupExpiredNodeClaim("metrics-node", "default")
+	pod := setupTestPod("blocking", "metrics", "metrics-node", map[string]string{karpv1.DoNotDisruptAnnotationKey: "true"})
+	deprovisionController := &controller.DeprovisionController{Client: fake.NewClientBuilder().WithRuntimeObjects(pod, nc).Build()}
+
+	latency := func() *dto.Histogram {
+		var m dto.Metric
+		observer := metrics.UnblockLatency.With(prometheus.Labels{metrics.DisruptionReasonLabel: string(controller.ReasonExpired)})
+		assert.NoError(t, observer.(prometheus.Metric).Write(&m))
+		return m.GetHistogram()
+	}
+	before := latency()
+	deprovisionController.HandleBlockingPods(context.TODO(), []corev1.Pod{*pod}, controller.BlockedNode{Name: "metrics-node", Reason: controller.ReasonExpired, NodeClaim: nc})
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.PatchCounter.With(prometheus.Labels{
+		metrics.KindLabel:      "Pod",
+		metrics.NamespaceLabel: "metrics",
+		metrics.OutcomeLabel:   metrics.OutcomeSuccess,
+	})))
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.UnblockedPodsCounter.With(prometheus.Labels{
+		metrics.NamespaceLabel: "metrics",
+		metrics.TriggerLabel:   metrics.TriggerWindow,
+	})))
+	after := latency()
+	assert.Equal(t, before.GetSampleCount()+1, after.GetSampleCount())
+	assert.InDelta(t, time.Hour.Seconds(), after.GetSampleSum()-before.GetSampleSum(), time.Minute.Seconds(), "Expected the time since the node expired to be observed")
+}
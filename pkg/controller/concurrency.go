@@ -0,0 +1,208 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+const (
+	// concurrencyRequeueBase is how long the most overdue waiting node waits before trying again
+	concurrencyRequeueBase = time.Minute
+	// concurrencyRequeueStep is added for every waiting node that is more overdue
+	concurrencyRequeueStep = 15 * time.Second
+	// waitingNodeTTL drops waiting nodes that stopped being reconciled, e.g. because they were deleted
+	waitingNodeTTL = 10 * time.Minute
+	// admissionGracePeriod keeps freshly admitted nodes tracked until the cache reflects their unblocked pods
+	admissionGracePeriod = time.Minute
+)
+
+// UnblockTracker limits how many nodes may have had do-not-disrupt annotations removed without having terminated yet,
+// both cluster-wide and per NodePool. A nil UnblockTracker admits every node.
+type UnblockTracker struct {
+	Client client.Client
+	// MaxNodes is the cluster-wide limit, 0 means unlimited.
+	MaxNodes int
+	// MaxNodesPerNodePool is the limit applied to each NodePool, 0 means unlimited.
+	MaxNodesPerNodePool int
+
+	mu        sync.Mutex
+	markerKey AnnotationKey
+	synced    bool
+	active    map[string]trackedNode
+	waiting   map[string]waitingNode
+}
+
+type trackedNode struct {
+	nodePool   string
+	admittedAt time.Time
+}
+
+type waitingNode struct {
+	nodePool  string
+	overdueAt time.Time
+	lastSeen  time.Time
+}
+
+// Admit reports whether the node may have its pods unblocked. Nodes that are turned away get a requeue delay
+// ordered by how overdue they are, so the most overdue node claims the next free slot.
+func (t *UnblockTracker) Admit(ctx context.Context, node BlockedNode, now time.Time) (bool, time.Duration, error) {
+	if t == nil {
+		return true, 0, nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.MaxNodes <= 0 && t.MaxNodesPerNodePool <= 0 {
+		return true, 0, nil
+	}
+
+	if err := t.sync(ctx); err != nil {
+		return false, 0, err
+	}
+	if err := t.prune(ctx, now); err != nil {
+		return false, 0, err
+	}
+	if _, ok := t.active[node.Name]; ok {
+		return true, 0, nil
+	}
+
+	nodePool := node.NodePool()
+	overdueAt := node.DisruptedSince(now)
+	t.waiting[node.Name] = waitingNode{nodePool: nodePool, overdueAt: overdueAt, lastSeen: now}
+
+	// Waiting nodes that are more overdue get first pick of the free slots
+	rank, poolRank := 0, 0
+	for name, w := range t.waiting {
+		if name == node.Name || !w.overdueAt.Before(overdueAt) {
+			continue
+		}
+		rank++
+		if w.nodePool == nodePool {
+			poolRank++
+		}
+	}
+	poolActive := 0
+	for _, a := range t.active {
+		if a.nodePool == nodePool {
+			poolActive++
+		}
+	}
+
+	if (t.MaxNodes > 0 && len(t.active)+rank >= t.MaxNodes) ||
+		(t.MaxNodesPerNodePool > 0 && poolActive+poolRank >= t.MaxNodesPerNodePool) {
+		return false, concurrencyRequeueBase + time.Duration(rank)*concurrencyRequeueStep, nil
+	}
+	delete(t.waiting, node.Name)
+	t.active[node.Name] = trackedNode{nodePool: nodePool, admittedAt: now}
+	return true, 0, nil
+}
+
+// Configure changes the limits and the annotation marking unblocked pods while the tracker is in use.
+// Changing the marker rebuilds the unblocked nodes from pods carrying the new one.
+func (t *UnblockTracker) Configure(limits ConcurrencySettings, markerKey AnnotationKey) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.MaxNodes = limits.MaxUnblockedNodes
+	t.MaxNodesPerNodePool = limits.MaxUnblockedNodesPerNodePool
+	if !reflect.DeepEqual(markerKey, t.marker()) {
+		t.markerKey = markerKey
+		t.synced = false
+	}
+}
+
+// marker returns the annotation marking unblocked pods, OriginalDoNotDisruptKey unless configured otherwise.
+func (t *UnblockTracker) marker() AnnotationKey {
+	if t.markerKey.Name == "" {
+		return DefaultAnnotationKeys.OriginalDoNotDisrupt
+	}
+	return t.markerKey
+}
+
+// sync reconstructs the unblocked nodes from pods carrying the original do-not-disrupt marker the first time it's called.
+func (t *UnblockTracker) sync(ctx context.Context) error {
+	if t.synced {
+		return nil
+	}
+	var podList corev1.PodList
+	if err := t.Client.List(ctx, &podList); err != nil {
+		return fmt.Errorf("failed getting pods from cache: %w", err)
+	}
+	t.active = map[string]trackedNode{}
+	t.waiting = map[string]waitingNode{}
+	for _, pod := range podList.Items {
+		if !t.marker().Has(pod.Annotations) || pod.Spec.NodeName == "" {
+			continue
+		}
+		if _, ok := t.active[pod.Spec.NodeName]; ok {
+			continue
+		}
+		node := &corev1.Node{}
+		if err := t.Client.Get(ctx, types.NamespacedName{Name: pod.Spec.NodeName}, node); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("failed getting node %s: %w", pod.Spec.NodeName, err)
+		}
+		// Treated as admitted long ago so nodes whose pods have since been restored are released on the next prune
+		t.active[node.Name] = trackedNode{nodePool: node.Labels[karpv1.NodePoolLabelKey]}
+	}
+	log.FromContext(ctx).Info(fmt.Sprintf("Reconstructed %d unblocked nodes from cache", len(t.active)))
+	t.synced = true
+	return nil
+}
+
+// prune releases nodes that terminated or no longer have unblocked pods, and forgets stale waiting nodes.
+func (t *UnblockTracker) prune(ctx context.Context, now time.Time) error {
+	for name, w := range t.waiting {
+		if now.Sub(w.lastSeen) > waitingNodeTTL {
+			delete(t.waiting, name)
+		}
+	}
+	for name, a := range t.active {
+		node := &corev1.Node{}
+		if err := t.Client.Get(ctx, types.NamespacedName{Name: name}, node); err != nil {
+			if errors.IsNotFound(err) {
+				delete(t.active, name)
+				continue
+			}
+			return fmt.Errorf("failed getting node %s: %w", name, err)
+		}
+		// Terminating nodes keep their slot until they're gone even once their pods have been evicted
+		if node.DeletionTimestamp != nil || now.Sub(a.admittedAt) < admissionGracePeriod {
+			continue
+		}
+		unblocked, err := t.hasUnblockedPods(ctx, name)
+		if err != nil {
+			return err
+		}
+		if !unblocked {
+			delete(t.active, name)
+		}
+	}
+	return nil
+}
+
+func (t *UnblockTracker) hasUnblockedPods(ctx context.Context, nodeName string) (bool, error) {
+	var podList corev1.PodList
+	if err := t.Client.List(ctx, &podList, client.MatchingFields{"spec.nodeName": nodeName}); err != nil {
+		return false, fmt.Errorf("failed getting pods from cache: %w", err)
+	}
+	for _, pod := range podList.Items {
+		if t.marker().Has(pod.Annotations) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
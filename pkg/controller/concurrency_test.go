@@ -0,0 +1,128 @@
+package controller_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/clienthelpers"
+	"github.com/jukie/karpenter-deprovision-controller/pkg/controller"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+func setupTestNode(name, nodePool string) *corev1.Node {
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{karpv1.NodePoolLabelKey: nodePool}}}
+}
+
+// setupBlockedNode returns a node that expired the given amount of time ago
+func setupBlockedNode(name, nodePool string, overdue time.Duration) controller.BlockedNode {
+	nc := setupTestNodeClaim(name, 2*time.Hour+overdue, "2h")
+	nc.Labels = map[string]string{karpv1.NodePoolLabelKey: nodePool}
+	return controller.BlockedNode{Name: name, Reason: controller.ReasonExpired, NodeClaim: nc}
+}
+
+func newTestTracker(maxNodes, maxPerPool int, objList ...runtime.Object) *controller.UnblockTracker {
+	return &controller.UnblockTracker{
+		Client: fake.NewClientBuilder().
+			WithRuntimeObjects(objList...).
+			WithIndex(&corev1.Pod{}, "spec.nodeName", clienthelpers.PodIdxFunc).
+			Build(),
+		MaxNodes:            maxNodes,
+		MaxNodesPerNodePool: maxPerPool,
+	}
+}
+
+func TestUnblockTracker_Admit(t *testing.T) {
+	now := time.Now()
+	nodes := []runtime.Object{setupTestNode("node-a", "default"), setupTestNode("node-b", "default"), setupTestNode("node-c", "gpu")}
+
+	t.Run("Nil tracker admits everything", func(t *testing.T) {
+		var tracker *controller.UnblockTracker
+		admitted, _, err := tracker.Admit(context.TODO(), setupBlockedNode("node-a", "default", time.Hour), now)
+		assert.NoError(t, err)
+		assert.True(t, admitted)
+	})
+
+	t.Run("Global limit", func(t *testing.T) {
+		tracker := newTestTracker(1, 0, nodes...)
+		admitted, _, err := tracker.Admit(context.TODO(), setupBlockedNode("node-a", "default", time.Hour), now)
+		assert.NoError(t, err)
+		assert.True(t, admitted)
+
+		admitted, requeueAfter, err := tracker.Admit(context.TODO(), setupBlockedNode("node-c", "gpu", time.Hour), now)
+		assert.NoError(t, err)
+		assert.False(t, admitted)
+		assert.Greater(t, requeueAfter, time.Duration(0))
+
+		// Already admitted nodes stay admitted
+		admitted, _, err = tracker.Admit(context.TODO(), setupBlockedNode("node-a", "default", time.Hour), now)
+		assert.NoError(t, err)
+		assert.True(t, admitted)
+	})
+
+	t.Run("Per NodePool limit", func(t *testing.T) {
+		tracker := newTestTracker(0, 1, nodes...)
+		admitted, _, _ := tracker.Admit(context.TODO(), setupBlockedNode("node-a", "default", time.Hour), now)
+		assert.True(t, admitted)
+		admitted, _, _ = tracker.Admit(context.TODO(), setupBlockedNode("node-b", "default", time.Hour), now)
+		assert.False(t, admitted)
+		admitted, _, _ = tracker.Admit(context.TODO(), setupBlockedNode("node-c", "gpu", time.Hour), now)
+		assert.True(t, admitted)
+	})
+
+	t.Run("Reconstructed from cache", func(t *testing.T) {
+		unblocked := setupTestPod("unblocked", "testing", "node-a", map[string]string{controller.OriginalDoNotDisruptKey: "true"})
+		tracker := newTestTracker(1, 0, append(nodes, unblocked)...)
+		admitted, _, err := tracker.Admit(context.TODO(), setupBlockedNode("node-b", "default", time.Hour), now)
+		assert.NoError(t, err)
+		assert.False(t, admitted)
+	})
+
+	t.Run("Most overdue node claims the next slot", func(t *testing.T) {
+		unblocked := setupTestPod("unblocked", "testing", "node-a", map[string]string{controller.OriginalDoNotDisruptKey: "true"})
+		tracker := newTestTracker(1, 0, append(nodes, unblocked)...)
+
+		admitted, moreOverdueDelay, _ := tracker.Admit(context.TODO(), setupBlockedNode("node-c", "gpu", 48*time.Hour), now)
+		assert.False(t, admitted)
+		admitted, lessOverdueDelay, _ := tracker.Admit(context.TODO(), setupBlockedNode("node-b", "default", time.Hour), now)
+		assert.False(t, admitted)
+		assert.Less(t, moreOverdueDelay, lessOverdueDelay)
+
+		// Free the slot by restoring the unblocked pod
+		assert.NoError(t, tracker.Client.Delete(context.TODO(), unblocked))
+		admitted, _, _ = tracker.Admit(context.TODO(), setupBlockedNode("node-b", "default", time.Hour), now)
+		assert.False(t, admitted, "Expected less overdue node to yield to the more overdue one")
+		admitted, _, _ = tracker.Admit(context.TODO(), setupBlockedNode("node-c", "gpu", 48*time.Hour), now)
+		assert.True(t, admitted)
+	})
+
+	t.Run("Terminated nodes free their slot", func(t *testing.T) {
+		tracker := newTestTracker(1, 0, nodes...)
+		admitted, _, _ := tracker.Admit(context.TODO(), setupBlockedNode("node-a", "default", time.Hour), now)
+		assert.True(t, admitted)
+		assert.NoError(t, tracker.Client.Delete(context.TODO(), &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}))
+		admitted, _, _ = tracker.Admit(context.TODO(), setupBlockedNode("node-b", "default", time.Hour), now)
+		assert.True(t, admitted)
+	})
+}
+
+func TestHandleBlockingPods_ConcurrencyLimit(t *testing.T) {
+	unblocked := setupTestPod("unblocked", "testing", "node-a", map[string]string{controller.OriginalDoNotDisruptKey: "true"})
+	pod := setupTestPod("blocking-no-sched", "testing", "node-b", map[string]string{karpv1.DoNotDisruptAnnotationKey: "true"})
+	tracker := newTestTracker(1, 0, setupTestNode("node-a", "default"), setupTestNode("node-b", "default"), unblocked, pod)
+	deprovisionController := &controller.DeprovisionController{Client: tracker.Client, Tracker: tracker}
+
+	result := deprovisionController.HandleBlockingPods(context.TODO(), []corev1.Pod{*pod}, setupBlockedNode("node-b", "default", time.Hour))
+	assert.Greater(t, result.RequeueAfter, time.Duration(0))
+
+	updatedPod := &corev1.Pod{}
+	assert.NoError(t, tracker.Client.Get(context.TODO(), client.ObjectKeyFromObject(pod), updatedPod))
+	assert.Equal(t, "true", updatedPod.Annotations[karpv1.DoNotDisruptAnnotationKey], "Expected annotation to be unchanged")
+}
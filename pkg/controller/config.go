@@ -0,0 +1,230 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	ConfigAPIVersion = "deprovision.jukie.dev/v1alpha1"
+	ConfigKind       = "ControllerConfig"
+)
+
+// Config is the versioned controller configuration file. Everything except SyncPeriod is applied while running.
+type Config struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	// SyncPeriod is how often the cache resyncs, changes only take effect after a restart.
+	SyncPeriod metav1.Duration `json:"syncPeriod"`
+	// Annotations are the pod annotation keys the controller reads and writes.
+	Annotations AnnotationSettings `json:"annotations"`
+	// Windows bounds disruption window durations and decides how invalid windows are treated.
+	Windows WindowSettings `json:"windows"`
+	// EventMatchers replace the built-in event matchers. The event cache is narrowed at startup, so matchers that
+	// need a different kind or reason only take effect after a restart.
+	EventMatchers EventMatchers `json:"eventMatchers,omitempty"`
+	// Concurrency limits how many nodes may be unblocked at once.
+	Concurrency ConcurrencySettings `json:"concurrency"`
+	// Namespaces restricts which namespaces have pods unblocked.
+	Namespaces NamespaceFilter `json:"namespaces"`
+	// Events decides which decisions are recorded as Kubernetes Events on pods and nodes.
+	Events EventSettings `json:"events"`
+}
+
+// WindowSettings bounds disruption window durations and decides how invalid windows are treated.
+type WindowSettings struct {
+	// DefaultDuration applies to windows without a duration or, with FailOpen, an invalid one.
+	DefaultDuration metav1.Duration `json:"defaultDuration"`
+	// MinimumDuration is the shortest duration accepted, shorter ones fall back to DefaultDuration.
+	MinimumDuration metav1.Duration `json:"minimumDuration"`
+	// InvalidPolicy decides how windows with an invalid schedule, duration or timezone are treated.
+	InvalidPolicy InvalidWindowPolicy `json:"invalidPolicy"`
+	// NamespaceInvalidPolicies override InvalidPolicy for pods in the given namespaces.
+	NamespaceInvalidPolicies map[string]InvalidWindowPolicy `json:"namespaceInvalidPolicies,omitempty"`
+	// DefaultSchedule is the window used instead of invalid ones with the DefaultWindow policy, lasting DefaultDuration.
+	DefaultSchedule string `json:"defaultSchedule,omitempty"`
+	// DefaultTimezone is the timezone DefaultSchedule is evaluated in, UTC when empty.
+	DefaultTimezone string `json:"defaultTimezone,omitempty"`
+}
+
+// DefaultWindowSettings keep windows at least 3 hours long and treat invalid schedules as always active.
+var DefaultWindowSettings = WindowSettings{
+	DefaultDuration: metav1.Duration{Duration: 3 * time.Hour},
+	MinimumDuration: metav1.Duration{Duration: 3 * time.Hour},
+	InvalidPolicy:   InvalidWindowFailOpen,
+}
+
+// ForNamespace returns the settings applying to pods in the namespace, with its invalid window policy override.
+func (s WindowSettings) ForNamespace(namespace string) WindowSettings {
+	if policy, ok := s.NamespaceInvalidPolicies[namespace]; ok {
+		s.InvalidPolicy = policy
+	}
+	return s
+}
+
+// defaultWindow parses the window used by the DefaultWindow policy.
+func (s WindowSettings) defaultWindow() (DisruptionWindow, error) {
+	if s.DefaultSchedule == "" {
+		return DisruptionWindow{}, fmt.Errorf("the DefaultWindow policy requires a default schedule")
+	}
+	location, err := LoadTimezone(s.DefaultTimezone)
+	if err != nil {
+		return DisruptionWindow{}, err
+	}
+	schedule, err := ParseSchedule(s.DefaultSchedule, location)
+	if err != nil {
+		return DisruptionWindow{}, fmt.Errorf("invalid default schedule %q: %w", s.DefaultSchedule, err)
+	}
+	return DisruptionWindow{Schedule: schedule, Duration: s.DefaultDuration.Duration}, nil
+}
+
+// Validate checks the invalid window policies and, when one of them needs it, the default window.
+func (s WindowSettings) Validate() error {
+	needsDefault := s.InvalidPolicy == InvalidWindowDefault
+	if _, err := ParseInvalidWindowPolicy(string(s.InvalidPolicy)); err != nil {
+		return fmt.Errorf("windows.invalidPolicy: %w", err)
+	}
+	for namespace, policy := range s.NamespaceInvalidPolicies {
+		if _, err := ParseInvalidWindowPolicy(string(policy)); err != nil {
+			return fmt.Errorf("windows.namespaceInvalidPolicies[%s]: %w", namespace, err)
+		}
+		needsDefault = needsDefault || policy == InvalidWindowDefault
+	}
+	if needsDefault || s.DefaultSchedule != "" || s.DefaultTimezone != "" {
+		if _, err := s.defaultWindow(); err != nil {
+			return fmt.Errorf("windows.defaultSchedule: %w", err)
+		}
+	}
+	return nil
+}
+
+// ConcurrencySettings mirror the UnblockTracker limits, 0 means unlimited.
+type ConcurrencySettings struct {
+	MaxUnblockedNodes            int `json:"maxUnblockedNodes"`
+	MaxUnblockedNodesPerNodePool int `json:"maxUnblockedNodesPerNodePool"`
+}
+
+// NamespaceFilter selects the namespaces whose pods may be unblocked. An empty Include allows every namespace
+// that isn't excluded.
+type NamespaceFilter struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// Allows reports whether pods in the namespace may be unblocked.
+func (f NamespaceFilter) Allows(namespace string) bool {
+	for _, ns := range f.Exclude {
+		if ns == namespace {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, ns := range f.Include {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultConfig returns the configuration the controller runs with when there's no configuration file.
+func DefaultConfig() *Config {
+	return &Config{
+		APIVersion:    ConfigAPIVersion,
+		Kind:          ConfigKind,
+		SyncPeriod:    metav1.Duration{Duration: 60 * time.Minute},
+		Annotations:   DefaultAnnotationSettings(),
+		Windows:       DefaultWindowSettings,
+		EventMatchers: DefaultEventMatchers,
+		Events:        DefaultEventSettings,
+	}
+}
+
+// ParseConfig reads a configuration file on top of base, so fields the file leaves out keep their base values.
+// The result is validated and its event matchers compiled.
+func ParseConfig(data []byte, base *Config) (*Config, error) {
+	config := *base
+	config.APIVersion, config.Kind = "", ""
+	// Slices are decoded into their existing elements, which belong to base and would keep fields the file leaves out
+	config.EventMatchers, config.Namespaces = nil, NamespaceFilter{}
+	config.Annotations.DeprecatedPrefixes = nil
+	config.Windows.NamespaceInvalidPolicies = nil
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("failed parsing configuration: %w", err)
+	}
+	if config.EventMatchers == nil {
+		config.EventMatchers = base.EventMatchers
+	}
+	if config.Namespaces.Include == nil && config.Namespaces.Exclude == nil {
+		config.Namespaces = base.Namespaces
+	}
+	if config.Annotations.DeprecatedPrefixes == nil {
+		config.Annotations.DeprecatedPrefixes = base.Annotations.DeprecatedPrefixes
+	}
+	if config.Windows.NamespaceInvalidPolicies == nil {
+		config.Windows.NamespaceInvalidPolicies = base.Windows.NamespaceInvalidPolicies
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	matchers, err := config.EventMatchers.Compile()
+	if err != nil {
+		return nil, err
+	}
+	config.EventMatchers = matchers
+	return &config, nil
+}
+
+// Hash identifies the effective configuration, including values that came from flags rather than the file.
+func (c *Config) Hash() string {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return shortHash(data)
+}
+
+func shortHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// Validate checks the configuration for values the controller can't work with.
+func (c *Config) Validate() error {
+	if c.APIVersion != ConfigAPIVersion || c.Kind != ConfigKind {
+		return fmt.Errorf("unsupported configuration %s %s, expected apiVersion %s and kind %s", c.APIVersion, c.Kind, ConfigAPIVersion, ConfigKind)
+	}
+	if c.SyncPeriod.Duration <= 0 {
+		return fmt.Errorf("syncPeriod must be positive")
+	}
+	if err := c.Annotations.Validate(); err != nil {
+		return err
+	}
+	if c.Windows.MinimumDuration.Duration < 0 {
+		return fmt.Errorf("windows.minimumDuration must not be negative")
+	}
+	if c.Windows.DefaultDuration.Duration < c.Windows.MinimumDuration.Duration {
+		return fmt.Errorf("windows.defaultDuration %s is shorter than windows.minimumDuration %s", c.Windows.DefaultDuration.Duration, c.Windows.MinimumDuration.Duration)
+	}
+	if err := c.Windows.Validate(); err != nil {
+		return err
+	}
+	if len(c.EventMatchers) == 0 {
+		return fmt.Errorf("at least one event matcher is required")
+	}
+	if c.Concurrency.MaxUnblockedNodes < 0 || c.Concurrency.MaxUnblockedNodesPerNodePool < 0 {
+		return fmt.Errorf("concurrency limits must not be negative")
+	}
+	if _, err := ParseEventVerbosity(string(c.Events.Verbosity)); err != nil {
+		return fmt.Errorf("events.verbosity: %w", err)
+	}
+	return nil
+}
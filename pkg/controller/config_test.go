@@ -0,0 +1,280 @@
+package controller_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/controller"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+const testConfigHeader = "apiVersion: deprovision.jukie.dev/v1alpha1\nkind: ControllerConfig\n"
+
+func writeTestConfig(t *testing.T, path, content string) {
+	t.Helper()
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+}
+
+func TestConfigWatcher_Load(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		check   func(t *testing.T, config *controller.Config)
+		wantErr bool
+	}{
+		{
+			name: "Overrides base values",
+			content: testConfigHeader + `syncPeriod: 30m
+annotations:
+  schedule: example.com/window
+windows:
+  defaultDuration: 4h
+  minimumDuration: 1h
+concurrency:
+  maxUnblockedNodes: 3
+namespaces:
+  exclude: [kube-system]
+`,
+			check: func(t *testing.T, config *controller.Config) {
+				assert.Equal(t, 30*time.Minute, config.SyncPeriod.Duration)
+				assert.Equal(t, "example.com/window", config.Annotations.Keys().Schedule.Name)
+				assert.Equal(t, controller.DisruptionWindowDurationKey, config.Annotations.Keys().Duration.Name)
+				assert.Equal(t, 4*time.Hour, config.Windows.DefaultDuration.Duration)
+				assert.Equal(t, time.Hour, config.Windows.MinimumDuration.Duration)
+				assert.Equal(t, 3, config.Concurrency.MaxUnblockedNodes)
+				assert.Equal(t, len(controller.DefaultEventMatchers), len(config.EventMatchers))
+				assert.False(t, config.Namespaces.Allows("kube-system"))
+			},
+		},
+		{
+			name: "Event matchers",
+			content: testConfigHeader + `eventMatchers:
+  - name: custom
+    reason: DisruptionBlocked
+    message: '^Cannot disrupt'
+`,
+			check: func(t *testing.T, config *controller.Config) {
+				name, ok := config.EventMatchers.Match(controller.DisruptionEvent{Reason: "DisruptionBlocked", Message: "Cannot disrupt Node: anything"})
+				assert.True(t, ok)
+				assert.Equal(t, "custom", name)
+			},
+		},
+		{
+			name: "Invalid window policies",
+			content: testConfigHeader + `windows:
+  invalidPolicy: FailClosed
+  namespaceInvalidPolicies:
+    payments: DefaultWindow
+  defaultSchedule: "0 2 * * 6"
+  defaultTimezone: Europe/Berlin
+`,
+			check: func(t *testing.T, config *controller.Config) {
+				assert.Equal(t, controller.InvalidWindowFailClosed, config.Windows.InvalidPolicy)
+				assert.Equal(t, controller.InvalidWindowDefault, config.Windows.ForNamespace("payments").InvalidPolicy)
+				assert.Equal(t, controller.InvalidWindowFailClosed, config.Windows.ForNamespace("testing").InvalidPolicy)
+				// The duration settings are kept
+				assert.Equal(t, 3*time.Hour, config.Windows.DefaultDuration.Duration)
+			},
+		},
+		{name: "Missing kind", content: "apiVersion: deprovision.jukie.dev/v1alpha1\n", wantErr: true},
+		{name: "Unknown apiVersion", content: "apiVersion: deprovision.jukie.dev/v2\nkind: ControllerConfig\n", wantErr: true},
+		{name: "Unknown field", content: testConfigHeader + "syncInterval: 30m\n", wantErr: true},
+		{name: "Minimum longer than default", content: testConfigHeader + "windows:\n  minimumDuration: 4h\n", wantErr: true},
+		{name: "Invalid annotation key", content: testConfigHeader + "annotations:\n  schedule: 'not a key'\n", wantErr: true},
+		{name: "Negative limit", content: testConfigHeader + "concurrency:\n  maxUnblockedNodesPerNodePool: -1\n", wantErr: true},
+		{name: "Invalid matcher", content: testConfigHeader + "eventMatchers:\n  - name: broken\n    message: '('\n", wantErr: true},
+		{name: "Unknown event verbosity", content: testConfigHeader + "events:\n  verbosity: Loud\n", wantErr: true},
+		{name: "Unknown invalid window policy", content: testConfigHeader + "windows:\n  invalidPolicy: FailSafe\n", wantErr: true},
+		{name: "Unknown namespace invalid window policy", content: testConfigHeader + "windows:\n  namespaceInvalidPolicies:\n    payments: Closed\n", wantErr: true},
+		{name: "Default window without schedule", content: testConfigHeader + "windows:\n  invalidPolicy: DefaultWindow\n", wantErr: true},
+		{name: "Namespace default window without schedule", content: testConfigHeader + "windows:\n  namespaceInvalidPolicies:\n    payments: DefaultWindow\n", wantErr: true},
+		{name: "Invalid default schedule", content: testConfigHeader + "windows:\n  defaultSchedule: 'not a schedule'\n", wantErr: true},
+		{name: "Zero sync period", content: testConfigHeader + "syncPeriod: 0s\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			writeTestConfig(t, path, tt.content)
+			watcher := &controller.ConfigWatcher{Path: path, Base: controller.DefaultConfig()}
+			config, err := watcher.Load(context.TODO())
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			tt.check(t, config)
+		})
+	}
+}
+
+func TestConfigWatcher_LoadKeepsBase(t *testing.T) {
+	base := controller.DefaultConfig()
+	base.Namespaces.Exclude = []string{"kube-system"}
+	names := make([]string, 0, len(base.EventMatchers))
+	for _, matcher := range base.EventMatchers {
+		names = append(names, matcher.Name)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeTestConfig(t, path, testConfigHeader+"eventMatchers:\n  - name: custom\nnamespaces:\n  exclude: [monitoring]\n")
+	watcher := &controller.ConfigWatcher{Path: path, Base: base}
+	_, err := watcher.Load(context.TODO())
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"kube-system"}, base.Namespaces.Exclude)
+	for i, matcher := range base.EventMatchers {
+		assert.Equal(t, names[i], matcher.Name)
+	}
+}
+
+func TestNamespaceFilter_Allows(t *testing.T) {
+	tests := []struct {
+		name      string
+		filter    controller.NamespaceFilter
+		namespace string
+		want      bool
+	}{
+		{name: "Empty filter", namespace: "testing", want: true},
+		{name: "Included", filter: controller.NamespaceFilter{Include: []string{"testing"}}, namespace: "testing", want: true},
+		{name: "Not included", filter: controller.NamespaceFilter{Include: []string{"other"}}, namespace: "testing", want: false},
+		{name: "Excluded", filter: controller.NamespaceFilter{Exclude: []string{"testing"}}, namespace: "testing", want: false},
+		{name: "Exclude wins", filter: controller.NamespaceFilter{Include: []string{"testing"}, Exclude: []string{"testing"}}, namespace: "testing", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.Allows(tt.namespace))
+		})
+	}
+}
+
+func TestHandleBlockingPods_Config(t *testing.T) {
+	inactiveSchedule := fmt.Sprintf("%d %d * * *", time.Now().UTC().Minute(), time.Now().Add(-4*time.Hour).UTC().Hour())
+
+	config := controller.DefaultConfig()
+	config.Annotations.Schedule = "example.com/window"
+	config.Annotations.OriginalDoNotDisrupt = "example.com/original"
+	config.Windows.DefaultDuration.Duration = 5 * time.Hour
+	config.Namespaces.Exclude = []string{"excluded"}
+
+	tests := []struct {
+		name                    string
+		pod                     *corev1.Pod
+		expectAnnotationRemoved bool
+	}{
+		{
+			name:                    "Configured schedule key",
+			pod:                     setupTestPod("configured-key", "testing", "test-node", map[string]string{karpv1.DoNotDisruptAnnotationKey: "true", "example.com/window": inactiveSchedule, controller.DisruptionWindowDurationKey: "3h"}),
+			expectAnnotationRemoved: false,
+		},
+		{
+			name:                    "Default duration",
+			pod:                     setupTestPod("default-duration", "testing", "test-node", map[string]string{karpv1.DoNotDisruptAnnotationKey: "true", "example.com/window": inactiveSchedule}),
+			expectAnnotationRemoved: true,
+		},
+		{
+			name:                    "Default schedule key is ignored",
+			pod:                     setupTestPod("default-key", "testing", "test-node", map[string]string{karpv1.DoNotDisruptAnnotationKey: "true", controller.DisruptionWindowSchedKey: inactiveSchedule}),
+			expectAnnotationRemoved: true,
+		},
+		{
+			name:                    "Excluded namespace",
+			pod:                     setupTestPod("excluded", "excluded", "test-node", map[string]string{karpv1.DoNotDisruptAnnotationKey: "true"}),
+			expectAnnotationRemoved: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deprovisionController := &controller.DeprovisionController{Client: fake.NewClientBuilder().WithRuntimeObjects(tt.pod).Build()}
+			deprovisionController.ApplyConfig(config)
+			deprovisionController.HandleBlockingPods(context.TODO(), []corev1.Pod{*tt.pod}, controller.BlockedNode{Name: "test-node", Reason: controller.ReasonExpired})
+
+			updatedPod := &corev1.Pod{}
+			assert.NoError(t, deprovisionController.Client.Get(context.TODO(), types.NamespacedName{Name: tt.pod.Name, Namespace: tt.pod.Namespace}, updatedPod))
+			if tt.expectAnnotationRemoved {
+				assert.NotContains(t, updatedPod.Annotations, karpv1.DoNotDisruptAnnotationKey)
+				assert.Equal(t, "true", updatedPod.Annotations["example.com/original"])
+				assert.NotContains(t, updatedPod.Annotations, controller.OriginalDoNotDisruptKey)
+			} else {
+				assert.Equal(t, "true", updatedPod.Annotations[karpv1.DoNotDisruptAnnotationKey])
+			}
+		})
+	}
+}
+
+func TestConfigWatcher_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeTestConfig(t, path, testConfigHeader+"concurrency:\n  maxUnblockedNodes: 1\n")
+
+	tracker := &controller.UnblockTracker{Client: fake.NewClientBuilder().WithRuntimeObjects(setupTestNode("node-a", "pool"), setupTestNode("node-b", "pool")).Build()}
+	deprovisionController := &controller.DeprovisionController{Tracker: tracker}
+	watcher := &controller.ConfigWatcher{Path: path, Base: controller.DefaultConfig(), Controller: deprovisionController, Interval: 10 * time.Millisecond}
+	config, err := watcher.Load(context.TODO())
+	assert.NoError(t, err)
+	deprovisionController.ApplyConfig(config)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	go func() { _ = watcher.Start(ctx) }()
+
+	admit := func(name string) bool {
+		ok, _, err := tracker.Admit(context.TODO(), setupBlockedNode(name, "pool", time.Hour), time.Now())
+		assert.NoError(t, err)
+		return ok
+	}
+	assert.True(t, admit("node-a"))
+	assert.False(t, admit("node-b"))
+
+	// Invalid configurations are rejected and the previous limits stay in place
+	writeTestConfig(t, path, testConfigHeader+"concurrency:\n  maxUnblockedNodes: -1\n")
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, admit("node-b"))
+
+	writeTestConfig(t, path, testConfigHeader+"concurrency:\n  maxUnblockedNodes: 2\n")
+	assert.Eventually(t, func() bool { return admit("node-b") }, time.Second, 10*time.Millisecond)
+}
+
+func TestConfigWatcher_ReloadBlackouts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blackouts.yaml")
+	now := time.Now().UTC()
+	writeTestConfig(t, path, fmt.Sprintf("dates:\n  - name: freeze\n    start: %s\n    end: %s\n",
+		now.AddDate(0, 0, -1).Format("2006-01-02"), now.AddDate(0, 0, 1).Format("2006-01-02")))
+
+	pod := setupTestPod("blocking", "testing", "test-node", map[string]string{karpv1.DoNotDisruptAnnotationKey: "true"})
+	deprovisionController := &controller.DeprovisionController{Client: fake.NewClientBuilder().WithRuntimeObjects(pod).Build()}
+	watcher := &controller.ConfigWatcher{BlackoutPath: path, Controller: deprovisionController, Interval: 10 * time.Millisecond}
+	calendar, err := watcher.LoadBlackouts(context.TODO())
+	assert.NoError(t, err)
+	deprovisionController.ApplyBlackouts(calendar)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	go func() { _ = watcher.Start(ctx) }()
+
+	unblocked := func() bool {
+		deprovisionController.HandleBlockingPods(context.TODO(), []corev1.Pod{*pod}, controller.BlockedNode{Name: "test-node", Reason: controller.ReasonExpired})
+		updatedPod := &corev1.Pod{}
+		assert.NoError(t, deprovisionController.Client.Get(context.TODO(), types.NamespacedName{Name: pod.Name, Namespace: pod.Namespace}, updatedPod))
+		return updatedPod.Annotations[karpv1.DoNotDisruptAnnotationKey] == ""
+	}
+	assert.False(t, unblocked())
+
+	// Invalid calendars are rejected and the previous blackouts stay in place
+	writeTestConfig(t, path, "dates:\n  - name: freeze\n    start: tomorrow\n")
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, unblocked())
+
+	writeTestConfig(t, path, "dates: []\n")
+	assert.Eventually(t, unblocked, time.Second, 10*time.Millisecond)
+}
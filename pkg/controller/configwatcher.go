@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/blackout"
+	"github.com/jukie/karpenter-deprovision-controller/pkg/metrics"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// configPollInterval is how often ConfigWatcher checks the configuration file for changes.
+// Kubelet takes up to a minute to update mounted ConfigMaps so there's no point in watching more closely.
+const configPollInterval = 10 * time.Second
+
+// ConfigWatcher loads the configuration file and blackout calendar into a DeprovisionController and reloads them
+// whenever the files change, e.g. when the ConfigMaps they're mounted from are updated. Invalid configurations and
+// calendars are rejected and the previous ones kept.
+type ConfigWatcher struct {
+	// Path is the configuration file, it isn't watched when empty.
+	Path string
+	// Base holds the values the file is read on top of, usually built from flags.
+	Base *Config
+	// BlackoutPath is the blackout calendar, it isn't watched when empty.
+	BlackoutPath string
+	Controller   *DeprovisionController
+	// Interval between checks of the files, configPollInterval when 0.
+	Interval time.Duration
+
+	fileHash     string
+	blackoutHash string
+	current      *Config
+}
+
+// Load reads the configuration file, it's applied to the controller by the caller on startup and by Start afterwards.
+func (w *ConfigWatcher) Load(ctx context.Context) (*Config, error) {
+	data, err := os.ReadFile(w.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading configuration %s: %w", w.Path, err)
+	}
+	w.fileHash = shortHash(data)
+	config, err := ParseConfig(data, w.Base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration %s: %w", w.Path, err)
+	}
+	if w.current != nil {
+		w.warnRestartRequired(ctx, w.current, config)
+	}
+	w.current = config
+	log.FromContext(ctx).Info(fmt.Sprintf("Loaded configuration %s with hash %s", w.Path, config.Hash()))
+	return config, nil
+}
+
+// LoadBlackouts reads the blackout calendar, it's applied to the controller by the caller on startup and by Start
+// afterwards.
+func (w *ConfigWatcher) LoadBlackouts(ctx context.Context) (*blackout.Calendar, error) {
+	data, err := os.ReadFile(w.BlackoutPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading blackout calendar %s: %w", w.BlackoutPath, err)
+	}
+	w.blackoutHash = shortHash(data)
+	calendar, err := blackout.ParseFile(w.BlackoutPath, data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid blackout calendar %s: %w", w.BlackoutPath, err)
+	}
+	log.FromContext(ctx).Info(fmt.Sprintf("Loaded blackout calendar %s with %d windows and %d dates", w.BlackoutPath, len(calendar.Windows), len(calendar.Dates)))
+	return calendar, nil
+}
+
+// Start polls the configuration file and blackout calendar until the context is cancelled. It implements manager.Runnable.
+func (w *ConfigWatcher) Start(ctx context.Context) error {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = configPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			w.reload(ctx)
+		}
+	}
+}
+
+// NeedLeaderElection lets every replica follow configuration changes.
+func (w *ConfigWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+// reload applies the configuration file and blackout calendar if their contents changed since they were last read.
+func (w *ConfigWatcher) reload(ctx context.Context) {
+	if w.Path != "" && w.changed(ctx, "configuration", w.Path, &w.fileHash) {
+		config, err := w.Load(ctx)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "Failed reloading configuration, keeping the current configuration")
+			metrics.ConfigReloadFailureCounter.Inc()
+		} else {
+			w.Controller.ApplyConfig(config)
+		}
+	}
+	if w.BlackoutPath != "" && w.changed(ctx, "blackout calendar", w.BlackoutPath, &w.blackoutHash) {
+		calendar, err := w.LoadBlackouts(ctx)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "Failed reloading blackout calendar, keeping the current blackouts")
+			metrics.ConfigReloadFailureCounter.Inc()
+		} else {
+			w.Controller.ApplyBlackouts(calendar)
+		}
+	}
+}
+
+// changed reports whether the contents of the file at path no longer match hash.
+// Failures to read it are only reported once per change of the file.
+func (w *ConfigWatcher) changed(ctx context.Context, kind, path string, hash *string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if *hash != "" {
+			log.FromContext(ctx).Error(err, fmt.Sprintf("Failed reading %s %s, keeping the current %s", kind, path, kind))
+			metrics.ConfigReloadFailureCounter.Inc()
+			*hash = ""
+		}
+		return false
+	}
+	return shortHash(data) != *hash
+}
+
+// warnRestartRequired logs changes that are only picked up when the controller restarts.
+func (w *ConfigWatcher) warnRestartRequired(ctx context.Context, old, updated *Config) {
+	if old.SyncPeriod != updated.SyncPeriod {
+		log.FromContext(ctx).Info(fmt.Sprintf("Configuration changed syncPeriod from %s to %s, the change takes effect after a restart", old.SyncPeriod.Duration, updated.SyncPeriod.Duration))
+	}
+	oldKind, oldReason := old.EventMatchers.CommonFields()
+	kind, reason := updated.EventMatchers.CommonFields()
+	if (oldKind != "" && kind != oldKind) || (oldReason != "" && reason != oldReason) {
+		log.FromContext(ctx).Info("Configuration changed the kind or reason of event matchers, events outside the current event cache are only seen after a restart")
+	}
+}
@@ -2,31 +2,38 @@ package controller
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/jukie/karpenter-deprovision-controller/pkg/blackout"
 	"github.com/jukie/karpenter-deprovision-controller/pkg/metrics"
 
 	"github.com/go-openapi/jsonpointer"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/robfig/cron/v3"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/clock"
 	ctrlruntime "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
-	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
 )
 
 const (
-	DisruptionWindowSchedKey      = "k8s.adsrvr.net/disruption-window-schedule"
-	DisruptionWindowDurationKey   = "k8s.adsrvr.net/disruption-window-duration"
+	DisruptionWindowSchedKey      = DefaultAnnotationPrefix + "/" + DisruptionWindowSchedName
+	DisruptionWindowDurationKey   = DefaultAnnotationPrefix + "/" + DisruptionWindowDurationName
+	DisruptionWindowTimezoneKey   = DefaultAnnotationPrefix + "/" + DisruptionWindowTimezoneName
+	OriginalDoNotDisruptKey       = DefaultAnnotationPrefix + "/" + OriginalDoNotDisruptName
 	DisruptionBlockedEventReason  = "DisruptionBlocked"
 	DisruptionBlockedEventMessage = "Cannot disrupt Node: state node is marked for deletion"
 	DisruptionBlockedEventKind    = "Node"
@@ -34,99 +41,519 @@ const (
 
 type DeprovisionController struct {
 	Client client.Client
+	// ReasonPolicies decides which disruption reasons may have their blocking pods unblocked.
+	// DefaultReasonPolicies is used when nil.
+	ReasonPolicies map[DisruptionReason]ReasonPolicy
+	// Resolver looks up DisruptionPolicies for each pod, only pod annotations are used when nil.
+	Resolver *WindowResolver
+	// Blackouts take precedence over every disruption window until a calendar is applied, no blackouts apply when nil.
+	Blackouts *blackout.Calendar
+	// EventSource is the Event API DisruptionBlocked events are read from, core/v1 when nil.
+	EventSource EventSource
+	// EventMatchers recognize events about blocked nodes until a configuration is applied, DefaultEventMatchers is used when nil.
+	EventMatchers EventMatchers
+	// Tracker limits how many nodes may be unblocked at once, there's no limit when nil.
+	Tracker *UnblockTracker
+	// EvictAfter enables evicting unblocked pods from nodes that are still running this long after they expired.
+	// 0 disables eviction.
+	EvictAfter time.Duration
+	// MaxBlockDuration unblocks pods whose node has been disrupted for this long even outside their disruption window.
+	// Pods can shorten it with the MaxBlockDurationKey annotation, 0 means pods may block forever unless theirs sets a limit.
+	MaxBlockDuration time.Duration
+	// Recorder records Kubernetes Events for the decisions taken about pods and nodes, as configured by the Events
+	// settings. No Events are recorded when nil.
+	Recorder record.EventRecorder
+	// DryRun is set when the client doesn't apply writes. No Events are recorded and patches, evictions and unblocked
+	// pods aren't counted in metrics, so nothing claims annotations were removed when they weren't.
+	DryRun bool
+
+	initOnce     sync.Once
+	pdbBackoff   workqueue.TypedRateLimiter[string]
+	eventRepeats *repeatFilter
+	config       atomic.Pointer[Config]
+	blackouts    atomic.Pointer[blackout.Calendar]
+}
+
+// ApplyConfig switches the controller to a new configuration, it's safe to call while reconciling.
+// The configuration's concurrency limits are applied to the Tracker.
+func (c *DeprovisionController) ApplyConfig(config *Config) {
+	c.config.Store(config)
+	c.Tracker.Configure(config.Concurrency, config.Annotations.Keys().OriginalDoNotDisrupt)
+	metrics.SetConfigInfo(config.Hash())
+}
+
+// ApplyBlackouts switches the controller to a new blackout calendar, it's safe to call while reconciling.
+func (c *DeprovisionController) ApplyBlackouts(calendar *blackout.Calendar) {
+	c.blackouts.Store(calendar)
+}
+
+// currentBlackouts returns the applied blackout calendar, or the Blackouts field when none was applied.
+func (c *DeprovisionController) currentBlackouts() *blackout.Calendar {
+	if calendar := c.blackouts.Load(); calendar != nil {
+		return calendar
+	}
+	return c.Blackouts
 }
 
+// currentConfig returns the applied configuration, or DefaultConfig with the EventMatchers field when none was applied.
+func (c *DeprovisionController) currentConfig() *Config {
+	if config := c.config.Load(); config != nil {
+		return config
+	}
+	config := DefaultConfig()
+	if c.EventMatchers != nil {
+		config.EventMatchers = c.EventMatchers
+	}
+	return config
+}
+
+// init sets up internal state so the zero value of DeprovisionController is usable.
+func (c *DeprovisionController) init() {
+	c.initOnce.Do(func() {
+		c.pdbBackoff = newPDBBackoff()
+		c.eventRepeats = newRepeatFilter(eventRepeatInterval)
+	})
+}
+
+// Reconcile handles a core/v1 DisruptionBlocked event.
 func (c *DeprovisionController) Reconcile(ctx context.Context, e *corev1.Event) (reconcile.Result, error) {
+	disruptionEvent, _ := CoreV1EventSource{}.Convert(e)
+	return c.ReconcileEvent(ctx, disruptionEvent)
+}
+
+// ReconcileEvent handles a DisruptionBlocked event read from any EventSource.
+func (c *DeprovisionController) ReconcileEvent(ctx context.Context, e DisruptionEvent) (reconcile.Result, error) {
+	defer metrics.ObserveReconcile("deprovision", time.Now())
 	// Get pods from expired NodeClaim
 	var podList corev1.PodList
-	if err := c.Client.List(ctx, &podList, client.MatchingFields{"spec.nodeName": e.InvolvedObject.Name}); err != nil {
+	if err := c.Client.List(ctx, &podList, client.MatchingFields{"spec.nodeName": e.NodeName}); err != nil {
 		return reconcile.Result{}, fmt.Errorf("failed getting pods from cache: %w", err)
 	}
 
+	node, err := c.blockedNode(ctx, e.NodeName, podList.Items)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
 	// Handle the blocking pods.
-	c.HandleBlockingPods(ctx, podList.Items, e.InvolvedObject.Name)
-	return reconcile.Result{}, nil
+	return c.HandleBlockingPods(ctx, podList.Items, node), nil
+}
+
+// blockedNode resolves the NodeClaim behind a node, e.g. from a DisruptionBlocked event, to find out why it's being disrupted.
+// DisruptionBlocked events have always been treated as expiration, which remains the fallback for nodes without a
+// NodeClaim. Nodes whose NodeClaim gives no reason for disrupting them are ReasonBlocked instead, so healthy nodes that
+// are merely disruption candidates don't get the Expired policy.
+func (c *DeprovisionController) blockedNode(ctx context.Context, nodeName string, pods []corev1.Pod) (BlockedNode, error) {
+	node := BlockedNode{Name: nodeName, Reason: ReasonExpired}
+	var ncList karpv1.NodeClaimList
+	if err := c.Client.List(ctx, &ncList, client.MatchingFields{"status.nodeName": node.Name}); err != nil {
+		return node, fmt.Errorf("failed getting nodeclaims from cache: %w", err)
+	}
+	if len(ncList.Items) == 0 {
+		return node, nil
+	}
+	node.NodeClaim = &ncList.Items[0]
+	node.Reason = ReasonBlocked
+	if reason, ok := DisruptionReasonFor(node.NodeClaim, pods, time.Now()); ok {
+		node.Reason = reason
+	}
+	return node, nil
 }
 
 func (c *DeprovisionController) Register(_ context.Context, mgr manager.Manager) error {
+	source := c.eventSource()
 	return ctrlruntime.NewControllerManagedBy(mgr).
 		Named("deprovision").
-		For(&corev1.Event{}, builder.WithPredicates(predicate.Funcs{
-			// Reconcile all expired nodes upon startup
-			CreateFunc: func(e event.CreateEvent) bool {
-				karpEvent := e.Object.(*corev1.Event)
-				return karpEvent.Message == DisruptionBlockedEventMessage &&
-					// Kind and Reason are filtered for in manager cache options but leaving here for reference
-					karpEvent.InvolvedObject.Kind == DisruptionBlockedEventKind &&
-					karpEvent.Reason == DisruptionBlockedEventReason
-			},
-			// Reconcile only when the NodeClaim becomes expired.
-			UpdateFunc:  func(e event.UpdateEvent) bool { return false },
-			DeleteFunc:  func(e event.DeleteEvent) bool { return false },
-			GenericFunc: func(e event.GenericEvent) bool { return false },
-		})).
-		Complete(reconcile.AsReconciler(mgr.GetClient(), c))
-}
-
-func (c *DeprovisionController) HandleBlockingPods(ctx context.Context, pods []corev1.Pod, nodeName string) {
-	// Loop over pods on expired Node and conditionally remove blocking annotations
+		For(source.NewObject(), builder.WithPredicates(c.EventPredicates())).
+		Complete(reconcile.Func(func(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+			obj := source.NewObject()
+			if err := c.Client.Get(ctx, req.NamespacedName, obj); err != nil {
+				return reconcile.Result{}, client.IgnoreNotFound(err)
+			}
+			disruptionEvent, _ := source.Convert(obj)
+			return c.ReconcileEvent(ctx, disruptionEvent)
+		}))
+}
+
+// eventSource returns the configured EventSource, core/v1 Events when unset.
+func (c *DeprovisionController) eventSource() EventSource {
+	if c.EventSource == nil {
+		return CoreV1EventSource{}
+	}
+	return c.EventSource
+}
+
+// HandleBlockingPods removes do-not-disrupt annotations from pods on the node whose disruption window is active,
+// escalating to evicting them once the node has been stuck for longer than EvictAfter.
+// The returned result requeues the node when PodDisruptionBudgets or concurrency limits held back any pods,
+// or otherwise when the next disruption window of a pod that's still blocking opens.
+func (c *DeprovisionController) HandleBlockingPods(ctx context.Context, pods []corev1.Pod, node BlockedNode) reconcile.Result {
+	c.init()
+	now := time.Now()
+	result, nextCheck, unblocked := c.unblockPods(ctx, pods, node, now)
+	if result.RequeueAfter == 0 {
+		result = c.evictUnblockedPods(ctx, pods, node, unblocked > 0)
+	}
+	if !nextCheck.IsZero() && (result.RequeueAfter == 0 || nextCheck.Sub(now) < result.RequeueAfter) {
+		log.FromContext(ctx).V(1).Info(fmt.Sprintf("Requeueing node %s for the next disruption window at %s", node.Name, nextCheck.Format(time.RFC3339)))
+		result.RequeueAfter = nextCheck.Sub(now)
+	}
+	return result
+}
+
+// unblockPods removes do-not-disrupt annotations and returns how many pods were unblocked, along with when the
+// earliest disruption window or max block deadline of the pods left blocking comes up.
+func (c *DeprovisionController) unblockPods(ctx context.Context, pods []corev1.Pod, node BlockedNode, now time.Time) (reconcile.Result, time.Time, int) {
+	if !c.reasonPolicy(node.Reason).AllowUnblock {
+		log.FromContext(ctx).V(1).Info(fmt.Sprintf("Node %s is blocked for reason %s which is not configured for unblocking, skipping", node.Name, node.Reason))
+		return reconcile.Result{}, time.Time{}, 0
+	}
+
+	var nextCheck time.Time
+	config := c.currentConfig()
+	keys := config.Annotations.Keys()
+	// Loop over pods on disrupted Node and collect the ones whose blocking annotation may be removed
+	var candidates []unblockCandidate
+	blocking := 0
 	for _, pod := range pods {
 		if pod.Annotations[karpv1.DoNotDisruptAnnotationKey] == "" {
 			continue
 		}
-		// Check if configured Disruption Window is active
-		if !IsDisruptionWindowActive(ctx, pod.Namespace, pod.Name, pod.Annotations[DisruptionWindowSchedKey], pod.Annotations[DisruptionWindowDurationKey]) {
+		blocking++
+		eval := c.evaluatePod(ctx, &pod, node, config, keys, now)
+		switch eval.decision {
+		case PodNamespaceExcluded:
+			log.FromContext(ctx).V(1).Info(fmt.Sprintf("Namespace %s is excluded by the configuration, leaving do-not-disrupt annotation on pod %s/%s", pod.Namespace, pod.Namespace, pod.Name))
+			continue
+		case PodInBlackout:
+			log.FromContext(ctx).Info(fmt.Sprintf("Blackout %s is active, leaving do-not-disrupt annotation on pod %s/%s", eval.blackout, pod.Namespace, pod.Name))
+			metrics.BlackoutSkipCounter.With(prometheus.Labels{
+				metrics.BlackoutLabel:  eval.blackout,
+				metrics.NamespaceLabel: pod.Namespace,
+			}).Inc()
+			continue
+		case PodResolveFailed:
+			log.FromContext(ctx).Error(eval.err, fmt.Sprintf("Failed to resolve disruption window for pod %s/%s", pod.Namespace, pod.Name))
 			continue
+		case PodPolicyDisabled:
+			log.FromContext(ctx).V(1).Info(fmt.Sprintf("Disruption policy %s disables unblocking for pod %s/%s, skipping", eval.window.Policy, pod.Namespace, pod.Name))
+			continue
+		}
+		if eval.window.Policy != "" {
+			log.FromContext(ctx).V(1).Info(fmt.Sprintf("Using disruption policy %s for pod %s/%s", eval.window.Policy, pod.Namespace, pod.Name))
+		}
+		if eval.invalidValue != "" {
+			c.recordEvent(&pod, corev1.EventTypeWarning, InvalidDisruptionWindowEventReason,
+				fmt.Sprintf("Disruption window %s is invalid, %s", eval.invalidValue, eval.invalidConsequence))
+		}
+		switch eval.decision {
+		case PodNoWindow, PodWindowActive:
+			candidates = append(candidates, unblockCandidate{pod: pod})
+		case PodMaxBlockDurationExceeded:
+			candidates = append(candidates, unblockCandidate{pod: pod, maxBlockDuration: eval.maxBlockDuration})
+		case PodWindowInactive:
+			opens := "as the disruption window never opens"
+			if !eval.nextWindow.IsZero() {
+				opens = "until the disruption window opens at " + eval.nextWindow.Format(time.RFC3339)
+			}
+			c.recordVerboseEvent(&pod, corev1.EventTypeNormal, DisruptionWindowInactiveEventReason,
+				fmt.Sprintf("Node %s %s, leaving %s %s", node.Name, node.Reason.description(), karpv1.DoNotDisruptAnnotationKey, opens))
+			nextCheck = earliest(nextCheck, eval.nextWindow)
+			// The deadline of nodes without a NodeClaim keeps moving so there's no point in checking back for it
+			if eval.maxBlockDuration > 0 && node.NodeClaim != nil {
+				nextCheck = earliest(nextCheck, eval.maxBlockDeadline)
+			}
 		}
+	}
+	if len(candidates) == 0 {
+		return reconcile.Result{}, nextCheck, 0
+	}
 
-		log.FromContext(ctx).Info(fmt.Sprintf("Node %s has exceeded its max lifetime and will now remove do-not-disrupt annotations from the following pod to allow for deprovisioning: namespace: %s, pod name: %s", nodeName, pod.Namespace, pod.Name))
-		patch := fmt.Sprintf(`[{"op":"remove", "path":"/metadata/annotations/%s"}]`, jsonpointer.Escape(karpv1.DoNotDisruptAnnotationKey))
-		rawPatch := client.RawPatch(types.JSONPatchType, []byte(patch))
-		if err := c.Client.Patch(ctx, &pod, rawPatch); err != nil {
-			log.FromContext(ctx).Error(err, fmt.Sprintf("Failed to remove annotations from pod %s/%s", pod.Namespace, pod.Name))
-			metrics.PatchCounter.With(prometheus.Labels{
-				metrics.KindLabel:      pod.Kind,
-				metrics.NameLabel:      pod.Name,
-				metrics.SucceededLabel: "false",
-			}).Inc()
+	allowed, err := c.nodePoolAllowsDisruption(ctx, node, clock.RealClock{})
+	if err != nil {
+		log.FromContext(ctx).Error(err, fmt.Sprintf("Failed to check nodepool disruption budgets for node %s", node.Name))
+		return reconcile.Result{RequeueAfter: budgetRequeueInterval}, nextCheck, 0
+	}
+	if !allowed {
+		log.FromContext(ctx).Info(fmt.Sprintf("Nodepool %s disruption budgets don't allow disrupting node %s for reason %s, requeueing in %s", node.NodePool(), node.Name, node.Reason, budgetRequeueInterval))
+		return reconcile.Result{RequeueAfter: budgetRequeueInterval}, nextCheck, 0
+	}
+
+	admitted, requeueAfter, err := c.Tracker.Admit(ctx, node, now)
+	if err != nil {
+		log.FromContext(ctx).Error(err, fmt.Sprintf("Failed to check concurrency limits for node %s", node.Name))
+		return reconcile.Result{RequeueAfter: c.pdbBackoff.When(node.Name)}, nextCheck, 0
+	}
+	if !admitted {
+		log.FromContext(ctx).Info(fmt.Sprintf("Concurrency limit reached, requeueing node %s in %s", node.Name, requeueAfter))
+		return reconcile.Result{RequeueAfter: requeueAfter}, nextCheck, 0
+	}
+
+	budget := newPDBBudget(c.Client, keys.OriginalDoNotDisrupt)
+	heldByPDB := false
+	unblocked := 0
+	for _, candidate := range candidates {
+		pod := candidate.pod
+		pdbName, allowed, err := budget.reserve(ctx, &pod)
+		if err != nil {
+			log.FromContext(ctx).Error(err, fmt.Sprintf("Failed to check pod disruption budgets for pod %s/%s", pod.Namespace, pod.Name))
+			heldByPDB = true
+			continue
+		}
+		if !allowed {
+			log.FromContext(ctx).Info(fmt.Sprintf("Pod disruption budget %s/%s allows no more disruptions, leaving do-not-disrupt annotation on pod %s/%s", pod.Namespace, pdbName, pod.Namespace, pod.Name))
+			heldByPDB = true
 			continue
 		}
-		log.FromContext(ctx).Info(fmt.Sprintf("Annotation %s removed from pod %s in namespace %s", karpv1.DoNotDisruptAnnotationKey, pod.Name, pod.Namespace))
+
+		trigger := metrics.TriggerWindow
+		if candidate.maxBlockDuration > 0 {
+			trigger = metrics.TriggerMaxBlockDuration
+			log.FromContext(ctx).Info(fmt.Sprintf("Pod %s/%s has blocked node %s for longer than its max block duration of %s, removing do-not-disrupt annotation outside of its disruption window", pod.Namespace, pod.Name, node.Name, candidate.maxBlockDuration))
+			c.recordEvent(&pod, corev1.EventTypeWarning, MaxBlockDurationExceededEventReason,
+				fmt.Sprintf("Blocked disruption of node %s for longer than %s, removing %s outside of the disruption window", node.Name, candidate.maxBlockDuration, karpv1.DoNotDisruptAnnotationKey))
+		} else {
+			log.FromContext(ctx).Info(fmt.Sprintf("Node %s %s and will now remove do-not-disrupt annotations from the following pod to allow for deprovisioning: namespace: %s, pod name: %s", node.Name, node.Reason.description(), pod.Namespace, pod.Name))
+		}
+		if c.unblockPod(ctx, &pod, keys.OriginalDoNotDisrupt.Name) {
+			unblocked++
+			if candidate.maxBlockDuration == 0 {
+				c.recordEvent(&pod, corev1.EventTypeNormal, DoNotDisruptRemovedEventReason,
+					fmt.Sprintf("Removed %s so node %s, which %s, can be disrupted", karpv1.DoNotDisruptAnnotationKey, node.Name, node.Reason.description()))
+			}
+			if c.DryRun {
+				continue
+			}
+			metrics.UnblockedPodsCounter.With(prometheus.Labels{
+				metrics.NamespaceLabel: pod.Namespace,
+				metrics.TriggerLabel:   trigger,
+			}).Inc()
+			if node.NodeClaim != nil {
+				metrics.UnblockLatency.With(prometheus.Labels{
+					metrics.DisruptionReasonLabel: string(node.Reason),
+				}).Observe(now.Sub(node.DisruptedSince(now)).Seconds())
+			}
+		}
+	}
+
+	if unblocked > 0 {
+		c.recordEvent(c.nodeReference(ctx, node.Name), corev1.EventTypeNormal, BlockingPodsUnblockedEventReason,
+			fmt.Sprintf("Removed %s from %d of %d blocking pods because the node %s", karpv1.DoNotDisruptAnnotationKey, unblocked, blocking, node.Reason.description()))
+	}
+
+	if heldByPDB {
+		requeueAfter := c.pdbBackoff.When(node.Name)
+		log.FromContext(ctx).Info(fmt.Sprintf("Requeueing node %s in %s to retry pods held back by pod disruption budgets", node.Name, requeueAfter))
+		return reconcile.Result{RequeueAfter: requeueAfter}, nextCheck, unblocked
+	}
+	c.pdbBackoff.Forget(node.Name)
+	return reconcile.Result{}, nextCheck, unblocked
+}
+
+// unblockCandidate is a pod whose do-not-disrupt annotation may be removed.
+type unblockCandidate struct {
+	pod corev1.Pod
+	// maxBlockDuration is set when the pod is unblocked outside its disruption window because it exceeded it
+	maxBlockDuration time.Duration
+}
+
+// unblockPod removes the do-not-disrupt annotation, recording the original value so it can be
+// restored if the node outlives the disruption window. It reports whether the annotation was removed.
+func (c *DeprovisionController) unblockPod(ctx context.Context, pod *corev1.Pod, markerKey string) bool {
+	original, err := json.Marshal(pod.Annotations[karpv1.DoNotDisruptAnnotationKey])
+	if err != nil {
+		log.FromContext(ctx).Error(err, fmt.Sprintf("Failed to encode do-not-disrupt annotation of pod %s/%s", pod.Namespace, pod.Name))
+		return false
+	}
+	patch := fmt.Sprintf(`[{"op":"add", "path":"/metadata/annotations/%s", "value":%s}, {"op":"remove", "path":"/metadata/annotations/%s"}]`,
+		jsonpointer.Escape(markerKey), original, jsonpointer.Escape(karpv1.DoNotDisruptAnnotationKey))
+	rawPatch := client.RawPatch(types.JSONPatchType, []byte(patch))
+	err = c.Client.Patch(ctx, pod, rawPatch)
+	if err != nil && (errors.IsInvalid(err) || !c.stillBlocking(ctx, pod)) {
+		// The event and NodeClaim reconcilers both handle a disrupted node, so the other one may have removed the
+		// annotation since the pod was read from the cache, which makes the remove operation fail
+		log.FromContext(ctx).V(1).Info(fmt.Sprintf("Annotation %s was already removed from pod %s/%s", karpv1.DoNotDisruptAnnotationKey, pod.Namespace, pod.Name))
+		return false
+	}
+	if !c.DryRun {
+		metrics.PatchCounter.With(prometheus.Labels{
+			metrics.KindLabel:      "Pod",
+			metrics.NamespaceLabel: pod.Namespace,
+			metrics.OutcomeLabel:   metrics.Outcome(err),
+		}).Inc()
+	}
+	if err != nil {
+		log.FromContext(ctx).Error(err, fmt.Sprintf("Failed to remove annotations from pod %s/%s", pod.Namespace, pod.Name))
+		return false
+	}
+	log.FromContext(ctx).Info(fmt.Sprintf("Annotation %s removed from pod %s in namespace %s", karpv1.DoNotDisruptAnnotationKey, pod.Name, pod.Namespace))
+	return true
+}
+
+// stillBlocking re-reads the pod to tell whether it still carries the do-not-disrupt annotation.
+func (c *DeprovisionController) stillBlocking(ctx context.Context, pod *corev1.Pod) bool {
+	current := &corev1.Pod{}
+	if err := c.Client.Get(ctx, client.ObjectKeyFromObject(pod), current); err != nil {
+		return !errors.IsNotFound(err)
 	}
+	return current.Annotations[karpv1.DoNotDisruptAnnotationKey] != ""
+}
+
+func (c *DeprovisionController) reasonPolicy(reason DisruptionReason) ReasonPolicy {
+	if c.ReasonPolicies == nil {
+		return DefaultReasonPolicies[reason]
+	}
+	return c.ReasonPolicies[reason]
+}
+
+// earliest returns the earlier of two times, ignoring zero times.
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
 }
 
 // IsDisruptionWindowActive checks if the current time is within the disruption window.
-func IsDisruptionWindowActive(ctx context.Context, podNamespace, podName string, disruptionWindowSched, disruptionWindowDuration string) bool {
-	pod := podNamespace + "/" + podName
-	if disruptionWindowSched == "" {
+// The schedule is evaluated in the given timezone, or UTC when empty. Invalid schedules and timezones fail open.
+func IsDisruptionWindowActive(ctx context.Context, podNamespace, podName string, disruptionWindowSched, disruptionWindowDuration, disruptionWindowTimezone string) bool {
+	parsed := parseDisruptionWindow(ctx, podNamespace, podName, disruptionWindowSched, disruptionWindowDuration, disruptionWindowTimezone, DefaultWindowSettings)
+	if !parsed.ok {
 		return true
 	}
-	schedule, err := cron.ParseStandard(fmt.Sprintf("TZ=UTC %s", disruptionWindowSched))
-	if err != nil {
-		log.FromContext(ctx).Error(err, fmt.Sprintf("Failed to parse disruption window schedule for pod %s/%s", podNamespace, pod))
+	return parsed.window.ActiveAt(time.Now())
+}
+
+// parseDisruptionWindow parses a pod's disruption window with the settings of its namespace, logging and counting
+// invalid values. Durations that are missing or shorter than the configured minimum get the configured default, and
+// invalid values are handled by the namespace's invalid window policy.
+// ok is false when there's no usable schedule, meaning the window is always active.
+func parseDisruptionWindow(ctx context.Context, podNamespace, podName string, disruptionWindowSched, disruptionWindowDuration, disruptionWindowTimezone string, settings WindowSettings) windowParse {
+	pod := podNamespace + "/" + podName
+	parsed := parseWindow(disruptionWindowSched, disruptionWindowDuration, disruptionWindowTimezone, settings.ForNamespace(podNamespace))
+	if parsed.timezoneErr != nil {
+		log.FromContext(ctx).Error(parsed.timezoneErr, fmt.Sprintf("Invalid disruption window timezone for %s, %s", pod, parsed.invalidPolicy.consequence("the window is always active")))
+		metrics.FailedAnnotationParseCounter.With(prometheus.Labels{
+			metrics.AnnotationType: "DisruptionWindowTimezone",
+			metrics.NamespaceLabel: podNamespace,
+		}).Inc()
+	}
+	if parsed.scheduleErr != nil {
+		log.FromContext(ctx).Error(parsed.scheduleErr, fmt.Sprintf("Failed to parse disruption window schedule for pod %s, %s", pod,
+			parsed.invalidPolicy.consequence("the window is always active")))
 		metrics.FailedAnnotationParseCounter.With(prometheus.Labels{
 			metrics.AnnotationType: "DisruptionWindowSchedule",
-			metrics.NameLabel:      pod,
+			metrics.NamespaceLabel: podNamespace,
+		}).Inc()
+	}
+	if parsed.durationErr != nil || parsed.durationTooShort {
+		consequence := fmt.Sprintf("using default of %s", settings.DefaultDuration.Duration)
+		if parsed.durationErr != nil {
+			consequence = parsed.invalidPolicy.consequence(consequence)
+		}
+		log.FromContext(ctx).Error(parsed.durationErr, fmt.Sprintf("Invalid or too short disruption window duration for %s, %s", pod, consequence))
+		metrics.FailedAnnotationParseCounter.With(prometheus.Labels{
+			metrics.AnnotationType: "DisruptionWindowDuration",
+			metrics.NamespaceLabel: podNamespace,
 		}).Inc()
-		return true
 	}
+	return parsed
+}
 
-	duration := 3 * time.Hour
-	if disruptionWindowDuration != "" {
-		if parsedDuration, err := time.ParseDuration(disruptionWindowDuration); err != nil || parsedDuration < 3*time.Hour {
-			log.FromContext(ctx).Error(err, fmt.Sprintf("Invalid or too short disruption window duration for %s, using default of 3 hours", pod))
-			metrics.FailedAnnotationParseCounter.With(prometheus.Labels{
-				metrics.AnnotationType: "DisruptionWindowDuration",
-				metrics.NameLabel:      pod,
-			}).Inc()
-		} else {
-			duration = parsedDuration
+// windowParse is the outcome of parsing a disruption window, along with every problem found on the way.
+type windowParse struct {
+	window DisruptionWindow
+	// ok is false when there's no usable schedule, meaning the window is always active
+	ok          bool
+	timezoneErr error
+	scheduleErr error
+	durationErr error
+	// durationTooShort is set when the duration parsed but is shorter than the configured minimum
+	durationTooShort bool
+	// invalidPolicy is the policy that decided the window because a value was invalid, empty when every value is valid
+	invalidPolicy InvalidWindowPolicy
+}
+
+// describeInvalid describes the first invalid value of the window and what the invalid window policy does about it.
+// Both are empty when every value is valid.
+func (p windowParse) describeInvalid(window ResolvedWindow) (value, consequence string) {
+	switch {
+	case p.scheduleErr != nil:
+		return fmt.Sprintf("schedule %q", window.Schedule), p.invalidPolicy.consequence(karpv1.DoNotDisruptAnnotationKey + " may be removed at any time")
+	case p.timezoneErr != nil:
+		return fmt.Sprintf("timezone %q", window.Timezone), p.invalidPolicy.consequence(karpv1.DoNotDisruptAnnotationKey + " may be removed at any time")
+	case p.durationErr != nil:
+		return fmt.Sprintf("duration %q", window.Duration), p.invalidPolicy.consequence("using the default duration")
+	}
+	return "", ""
+}
+
+// invalid reports whether the schedule, duration or timezone couldn't be parsed.
+func (p windowParse) invalid() bool {
+	return p.timezoneErr != nil || p.scheduleErr != nil || p.durationErr != nil
+}
+
+// parseWindow parses a disruption window the way the controller interprets it. Durations that are missing or too short
+// get the configured default, and windows with an invalid value are handled by settings.InvalidPolicy: FailOpen falls
+// back to the default duration or, for schedules and timezones, an always active window, FailClosed to a window that's
+// never active and DefaultWindow to the configured default window. Durations aren't checked without a schedule.
+func parseWindow(sched, duration, timezone string, settings WindowSettings) windowParse {
+	parsed := parseGivenWindow(sched, duration, timezone, settings)
+	if !parsed.invalid() {
+		return parsed
+	}
+	parsed.invalidPolicy = settings.InvalidPolicy
+	switch settings.InvalidPolicy {
+	case InvalidWindowFailClosed:
+		parsed.window, parsed.ok = DisruptionWindow{Schedule: neverSchedule{}, Duration: settings.DefaultDuration.Duration}, true
+	case InvalidWindowDefault:
+		window, err := settings.defaultWindow()
+		if err != nil {
+			// Validation keeps this from happening, failing closed is the safe choice if it does anyway
+			parsed.invalidPolicy = InvalidWindowFailClosed
+			window = DisruptionWindow{Schedule: neverSchedule{}, Duration: settings.DefaultDuration.Duration}
 		}
+		parsed.window, parsed.ok = window, true
+	default:
+		parsed.invalidPolicy = InvalidWindowFailOpen
+	}
+	return parsed
+}
+
+// parseGivenWindow parses a disruption window as given, falling back to the default duration for missing, invalid or
+// too short ones. Windows with an unknown timezone aren't usable since evaluating them in UTC would shift them by hours,
+// the schedule and duration are still parsed so every problem is reported.
+func parseGivenWindow(sched, duration, timezone string, settings WindowSettings) windowParse {
+	var parsed windowParse
+	if sched == "" {
+		return parsed
+	}
+	location, err := LoadTimezone(timezone)
+	if err != nil {
+		parsed.timezoneErr = err
+		location = time.UTC
+	}
+	schedule, err := ParseSchedule(sched, location)
+	if err != nil {
+		parsed.scheduleErr = err
+		return parsed
 	}
 
-	// Walk back in time for the duration associated with the schedule and check if current time is inside window
-	now := time.Now().UTC()
-	checkPoint := now.Add(-duration)
-	nextHit := schedule.Next(checkPoint)
-	return !nextHit.After(now)
+	parsed.window = DisruptionWindow{Schedule: schedule, Duration: settings.DefaultDuration.Duration}
+	parsed.ok = true
+	if duration != "" {
+		parsedDuration, err := time.ParseDuration(duration)
+		switch {
+		case err != nil:
+			parsed.durationErr = err
+		case parsedDuration < settings.MinimumDuration.Duration:
+			parsed.durationTooShort = true
+		default:
+			parsed.window.Duration = parsedDuration
+		}
+	}
+	if parsed.timezoneErr != nil {
+		parsed.window, parsed.ok = DisruptionWindow{}, false
+	}
+	return parsed
 }
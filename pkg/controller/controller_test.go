@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/jukie/karpenter-deprovision-controller/pkg/blackout"
 	"github.com/jukie/karpenter-deprovision-controller/pkg/clienthelpers"
 
 	corev1 "k8s.io/api/core/v1"
@@ -54,6 +55,10 @@ func TestDeprovisionController_Reconcile(t *testing.T) {
 		controller.DisruptionWindowDurationKey: "1h",
 	})
 
+	deletingNodeClaim := setupTestNodeClaim("test-node", time.Hour, "720h")
+	deletingNodeClaim.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	deletingNodeClaim.Finalizers = []string{karpv1.TerminationFinalizer}
+
 	disruptionBlockedEvent := &corev1.Event{
 		InvolvedObject: corev1.ObjectReference{
 			Name: "test-node",
@@ -107,6 +112,24 @@ func TestDeprovisionController_Reconcile(t *testing.T) {
 			objList:                 []runtime.Object{blockingActiveSchedulePod},
 			expectAnnotationRemoved: true,
 		},
+		{
+			name:                    "Pod on expired NodeClaim",
+			pod:                     blockingNoSchedulePod,
+			objList:                 []runtime.Object{blockingNoSchedulePod, setupTestNodeClaim("test-node", 3*time.Hour, "2h")},
+			expectAnnotationRemoved: true,
+		},
+		{
+			name:                    "Pod on deleting NodeClaim that hasn't expired",
+			pod:                     blockingNoSchedulePod,
+			objList:                 []runtime.Object{blockingNoSchedulePod, deletingNodeClaim},
+			expectAnnotationRemoved: true,
+		},
+		{
+			name:                    "Pod on NodeClaim without a disruption reason",
+			pod:                     blockingNoSchedulePod,
+			objList:                 []runtime.Object{blockingNoSchedulePod, setupTestNodeClaim("test-node", time.Hour, "720h")},
+			expectAnnotationRemoved: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -115,6 +138,7 @@ func TestDeprovisionController_Reconcile(t *testing.T) {
 			deprovisionController.Client = fake.NewClientBuilder().
 				WithRuntimeObjects(tt.objList...).
 				WithIndex(&corev1.Pod{}, "spec.nodeName", clienthelpers.PodIdxFunc).
+				WithIndex(&karpv1.NodeClaim{}, "status.nodeName", clienthelpers.NodeClaimIdxFunc).
 				Build()
 
 			_, err := deprovisionController.Reconcile(context.TODO(), disruptionBlockedEvent)
@@ -189,7 +213,7 @@ func TestHandleBlockingPods(t *testing.T) {
 			deprovisionController := &controller.DeprovisionController{
 				Client: fakeClient,
 			}
-			deprovisionController.HandleBlockingPods(context.TODO(), tt.pods, "test-node")
+			deprovisionController.HandleBlockingPods(context.TODO(), tt.pods, controller.BlockedNode{Name: "test-node", Reason: controller.ReasonExpired})
 
 			for _, pod := range tt.pods {
 				updatedPod := &corev1.Pod{}
@@ -207,12 +231,15 @@ func TestHandleBlockingPods(t *testing.T) {
 }
 
 func TestIsDisruptionWindowActive(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	assert.NoError(t, err)
 	podName := "test-pod"
 	podNamespace := "test-namespace"
 	tests := []struct {
 		name                     string
 		disruptionWindowSched    string
 		disruptionWindowDuration string
+		disruptionWindowTimezone string
 		want                     bool
 	}{
 		{
@@ -238,10 +265,106 @@ func TestIsDisruptionWindowActive(t *testing.T) {
 			disruptionWindowSched: "hello",
 			want:                  true,
 		},
+		{
+			name:                     "Active schedule in timezone",
+			disruptionWindowSched:    fmt.Sprintf("%d %d * * *", time.Now().In(tokyo).Minute(), time.Now().In(tokyo).Hour()),
+			disruptionWindowTimezone: "Asia/Tokyo",
+			want:                     true,
+		},
+		{
+			name:                     "Schedule outside window once converted to timezone",
+			disruptionWindowSched:    fmt.Sprintf("%d %d * * *", time.Now().UTC().Minute(), time.Now().UTC().Hour()),
+			disruptionWindowTimezone: "Asia/Tokyo",
+			want:                     false,
+		},
+		{
+			name:                     "Unknown timezone fails open instead of using UTC",
+			disruptionWindowSched:    fmt.Sprintf("%d %d * * *", time.Now().UTC().Minute(), time.Now().Add(-4*time.Hour).UTC().Hour()),
+			disruptionWindowDuration: "3h",
+			disruptionWindowTimezone: "Mars/Olympus_Mons",
+			want:                     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equalf(t, tt.want, controller.IsDisruptionWindowActive(context.Background(), podNamespace, podName, tt.disruptionWindowSched, tt.disruptionWindowDuration, tt.disruptionWindowTimezone), "isDisruptionWindowActive(%v, %v, %v, %v, %v)", podNamespace, podName, tt.disruptionWindowSched, tt.disruptionWindowDuration, tt.disruptionWindowTimezone)
+		})
+	}
+}
+
+func TestHandleBlockingPods_Blackout(t *testing.T) {
+	pod := setupTestPod("blocking-no-sched", "testing", "test-node", map[string]string{karpv1.DoNotDisruptAnnotationKey: "true"})
+	calendar, err := blackout.Parse([]byte(fmt.Sprintf("dates:\n  - name: freeze\n    start: %s\n    end: %s\n",
+		time.Now().Add(-time.Hour).UTC().Format(time.RFC3339), time.Now().Add(time.Hour).UTC().Format(time.RFC3339))))
+	assert.NoError(t, err)
+
+	deprovisionController := &controller.DeprovisionController{
+		Client:    fake.NewClientBuilder().WithRuntimeObjects(pod).Build(),
+		Blackouts: calendar,
+	}
+	deprovisionController.HandleBlockingPods(context.TODO(), []corev1.Pod{*pod}, controller.BlockedNode{Name: "test-node", Reason: controller.ReasonExpired})
+
+	updatedPod := &corev1.Pod{}
+	assert.NoError(t, deprovisionController.Client.Get(context.TODO(), types.NamespacedName{Name: pod.Name, Namespace: pod.Namespace}, updatedPod))
+	assert.Equal(t, "true", updatedPod.Annotations[karpv1.DoNotDisruptAnnotationKey], "Expected annotation to be unchanged during blackout")
+}
+
+func TestHandleBlockingPods_RequeueAtNextWindow(t *testing.T) {
+	// Truncated to the minute since cron schedules can't express seconds
+	now := time.Now().UTC().Truncate(time.Minute)
+	windowPod := func(name string, opensIn time.Duration) *corev1.Pod {
+		opensAt := now.Add(opensIn)
+		return setupTestPod(name, "testing", "test-node", map[string]string{
+			karpv1.DoNotDisruptAnnotationKey:    "true",
+			controller.DisruptionWindowSchedKey: fmt.Sprintf("%d %d * * *", opensAt.Minute(), opensAt.Hour()),
+		})
+	}
+
+	tests := []struct {
+		name             string
+		pods             []*corev1.Pod
+		maxBlockDuration time.Duration
+		expectRequeue    time.Duration
+	}{
+		{
+			name:          "Single pod waiting for its window",
+			pods:          []*corev1.Pod{windowPod("later", 2*time.Hour)},
+			expectRequeue: 2 * time.Hour,
+		},
+		{
+			name:          "Earliest window across pods",
+			pods:          []*corev1.Pod{windowPod("later", 5*time.Hour), windowPod("sooner", 4*time.Hour)},
+			expectRequeue: 4 * time.Hour,
+		},
+		{
+			name:          "Unblocked pods don't need a requeue",
+			pods:          []*corev1.Pod{setupTestPod("no-sched", "testing", "test-node", map[string]string{karpv1.DoNotDisruptAnnotationKey: "true"})},
+			expectRequeue: 0,
+		},
+		{
+			name:             "Max block deadline before the next window",
+			pods:             []*corev1.Pod{windowPod("later", 5*time.Hour)},
+			maxBlockDuration: 2 * time.Hour,
+			expectRequeue:    time.Hour,
+		},
 	}
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			assert.Equalf(t, tt.want, controller.IsDisruptionWindowActive(context.Background(), podNamespace, podName, tt.disruptionWindowSched, tt.disruptionWindowDuration), "isDisruptionWindowActive(%v, %v, %v, %v)", podNamespace, podName, tt.disruptionWindowSched, tt.disruptionWindowDuration)
+			var objList []runtime.Object
+			var pods []corev1.Pod
+			for _, pod := range tt.pods {
+				objList = append(objList, pod)
+				pods = append(pods, *pod)
+			}
+			deprovisionController := &controller.DeprovisionController{
+				Client:           fake.NewClientBuilder().WithRuntimeObjects(objList...).Build(),
+				MaxBlockDuration: tt.maxBlockDuration,
+			}
+			// Expired an hour ago
+			node := setupBlockedNode("test-node", "default", time.Hour)
+			result := deprovisionController.HandleBlockingPods(context.TODO(), pods, node)
+			assert.InDelta(t, tt.expectRequeue.Seconds(), result.RequeueAfter.Seconds(), 90)
 		})
 	}
 }
@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// eventRepeatInterval is the minimum time between reconciles triggered by repeats of DisruptionBlocked events for a node.
+// Karpenter re-emits the event on every disruption loop, far more often than anything changes.
+const eventRepeatInterval = time.Minute
+
+// EventPredicates selects events matching the EventMatchers when they're created and whenever Kubernetes aggregates a repeat
+// into them, either by bumping count or series.count depending on the Event API they were written through.
+// Repeats are deduplicated per node.
+func (c *DeprovisionController) EventPredicates() predicate.Funcs {
+	c.init()
+	source := c.eventSource()
+	return predicate.Funcs{
+		// Reconcile all expired nodes upon startup
+		CreateFunc: func(e event.CreateEvent) bool {
+			disruptionEvent, ok := source.Convert(e.Object)
+			return ok && c.matchesEvent(disruptionEvent)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldEvent, oldOk := source.Convert(e.ObjectOld)
+			newEvent, newOk := source.Convert(e.ObjectNew)
+			return oldOk && newOk && newEvent.Count > oldEvent.Count &&
+				c.matchesEvent(newEvent) &&
+				c.eventRepeats.allow(newEvent.NodeName, time.Now())
+		},
+		DeleteFunc:  func(e event.DeleteEvent) bool { return false },
+		GenericFunc: func(e event.GenericEvent) bool { return false },
+	}
+}
+
+// matchesEvent checks the event against the configured matchers. Events that made it through the cache selector
+// but match no rule are counted, since a Karpenter upgrade changing its messages would otherwise go unnoticed.
+func (c *DeprovisionController) matchesEvent(e DisruptionEvent) bool {
+	if _, ok := c.eventMatchers().Match(e); ok {
+		return true
+	}
+	log.Log.V(1).Info(fmt.Sprintf("Event %s for %s %s matched no event matcher, ignoring: %s", e.Reason, e.Kind, e.NodeName, e.Message))
+	metrics.UnmatchedEventCounter.With(prometheus.Labels{
+		metrics.KindLabel:        e.Kind,
+		metrics.EventReasonLabel: e.Reason,
+	}).Inc()
+	return false
+}
+
+// eventMatchers returns the configured EventMatchers, the built-in rules for every known Karpenter release when unset.
+func (c *DeprovisionController) eventMatchers() EventMatchers {
+	return c.currentConfig().EventMatchers
+}
+
+// repeatFilter lets through at most one repeat per node every interval.
+type repeatFilter struct {
+	interval time.Duration
+
+	mu       sync.Mutex
+	lastSeen map[string]time.Time
+}
+
+func newRepeatFilter(interval time.Duration) *repeatFilter {
+	return &repeatFilter{interval: interval, lastSeen: map[string]time.Time{}}
+}
+
+func (f *repeatFilter) allow(node string, now time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for name, seen := range f.lastSeen {
+		if now.Sub(seen) >= f.interval {
+			delete(f.lastSeen, name)
+		}
+	}
+	if _, ok := f.lastSeen[node]; ok {
+		return false
+	}
+	f.lastSeen[node] = now
+	return true
+}
@@ -0,0 +1,85 @@
+package controller_test
+
+import (
+	"testing"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/controller"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+func setupDisruptionBlockedEvent(nodeName string, count int32, series *corev1.EventSeries) *corev1.Event {
+	return &corev1.Event{
+		InvolvedObject: corev1.ObjectReference{Name: nodeName, Kind: controller.DisruptionBlockedEventKind},
+		Reason:         controller.DisruptionBlockedEventReason,
+		Message:        controller.DisruptionBlockedEventMessage,
+		Count:          count,
+		Series:         series,
+	}
+}
+
+func TestEventPredicates_Update(t *testing.T) {
+	otherEvent := setupDisruptionBlockedEvent("test-node", 2, nil)
+	otherEvent.Message = "Cannot disrupt Node: something else"
+
+	tests := []struct {
+		name     string
+		old, new *corev1.Event
+		want     bool
+	}{
+		{
+			name: "Count increased",
+			old:  setupDisruptionBlockedEvent("test-node", 1, nil),
+			new:  setupDisruptionBlockedEvent("test-node", 2, nil),
+			want: true,
+		},
+		{
+			name: "Count unchanged",
+			old:  setupDisruptionBlockedEvent("test-node", 2, nil),
+			new:  setupDisruptionBlockedEvent("test-node", 2, nil),
+			want: false,
+		},
+		{
+			name: "Series count increased",
+			old:  setupDisruptionBlockedEvent("test-node", 0, &corev1.EventSeries{Count: 2}),
+			new:  setupDisruptionBlockedEvent("test-node", 0, &corev1.EventSeries{Count: 3}),
+			want: true,
+		},
+		{
+			name: "Series started",
+			old:  setupDisruptionBlockedEvent("test-node", 0, nil),
+			new:  setupDisruptionBlockedEvent("test-node", 0, &corev1.EventSeries{Count: 2}),
+			want: true,
+		},
+		{
+			name: "Other message",
+			old:  setupDisruptionBlockedEvent("test-node", 1, nil),
+			new:  otherEvent,
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			predicates := (&controller.DeprovisionController{}).EventPredicates()
+			assert.Equal(t, tt.want, predicates.Update(event.UpdateEvent{ObjectOld: tt.old, ObjectNew: tt.new}))
+		})
+	}
+}
+
+func TestEventPredicates_DeduplicatesPerNode(t *testing.T) {
+	predicates := (&controller.DeprovisionController{}).EventPredicates()
+	update := func(nodeName string, from, to int32) bool {
+		return predicates.Update(event.UpdateEvent{
+			ObjectOld: setupDisruptionBlockedEvent(nodeName, from, nil),
+			ObjectNew: setupDisruptionBlockedEvent(nodeName, to, nil),
+		})
+	}
+
+	assert.True(t, update("test-node", 1, 2))
+	assert.False(t, update("test-node", 2, 3), "Expected repeat within the interval to be dropped")
+	assert.True(t, update("other-node", 1, 2), "Expected other nodes to be unaffected")
+	assert.True(t, predicates.Create(event.CreateEvent{Object: setupDisruptionBlockedEvent("test-node", 1, nil)}), "Expected creates to always be reconciled")
+}
@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	EventSourceAuto     = "auto"
+	EventSourceCoreV1   = "core/v1"
+	EventSourceEventsV1 = "events.k8s.io/v1"
+)
+
+// DisruptionEvent holds the fields of a Kubernetes Event the controller relies on, independent of the Event API it was read from.
+type DisruptionEvent struct {
+	Kind     string
+	NodeName string
+	Reason   string
+	Message  string
+	// Count is how many times the event was observed, including repeats aggregated into it.
+	Count int32
+}
+
+// EventSource is an Event API the controller can consume DisruptionBlocked events from.
+type EventSource interface {
+	// Name identifies the source in logs and flags.
+	Name() string
+	// NewObject returns an empty Event of the type served by the source.
+	NewObject() client.Object
+	// CacheSelector limits the cache to events about the given kind of object with the given reason, empty values match anything.
+	CacheSelector(kind, reason string) fields.Selector
+	// Convert extracts a DisruptionEvent, the second return value is false when obj isn't an Event of this source.
+	Convert(obj client.Object) (DisruptionEvent, bool)
+}
+
+// CoreV1EventSource reads core/v1 Events, aggregated repeats bump count or series.count.
+type CoreV1EventSource struct{}
+
+func (CoreV1EventSource) Name() string             { return EventSourceCoreV1 }
+func (CoreV1EventSource) NewObject() client.Object { return &corev1.Event{} }
+
+func (CoreV1EventSource) CacheSelector(kind, reason string) fields.Selector {
+	return eventSelector("involvedObject.kind", kind, reason)
+}
+
+func (CoreV1EventSource) Convert(obj client.Object) (DisruptionEvent, bool) {
+	e, ok := obj.(*corev1.Event)
+	if !ok {
+		return DisruptionEvent{}, false
+	}
+	count := e.Count
+	if e.Series != nil && e.Series.Count > count {
+		count = e.Series.Count
+	}
+	return DisruptionEvent{
+		Kind:     e.InvolvedObject.Kind,
+		NodeName: e.InvolvedObject.Name,
+		Reason:   e.Reason,
+		Message:  e.Message,
+		Count:    count,
+	}, true
+}
+
+// EventsV1EventSource reads events.k8s.io/v1 Events, aggregated repeats bump series.count or deprecatedCount.
+type EventsV1EventSource struct{}
+
+func (EventsV1EventSource) Name() string             { return EventSourceEventsV1 }
+func (EventsV1EventSource) NewObject() client.Object { return &eventsv1.Event{} }
+
+func (EventsV1EventSource) CacheSelector(kind, reason string) fields.Selector {
+	return eventSelector("regarding.kind", kind, reason)
+}
+
+func (EventsV1EventSource) Convert(obj client.Object) (DisruptionEvent, bool) {
+	e, ok := obj.(*eventsv1.Event)
+	if !ok {
+		return DisruptionEvent{}, false
+	}
+	count := e.DeprecatedCount
+	if e.Series != nil && e.Series.Count > count {
+		count = e.Series.Count
+	}
+	return DisruptionEvent{
+		Kind:     e.Regarding.Kind,
+		NodeName: e.Regarding.Name,
+		Reason:   e.Reason,
+		Message:  e.Note,
+		Count:    count,
+	}, true
+}
+
+func eventSelector(kindField, kind, reason string) fields.Selector {
+	set := fields.Set{}
+	if kind != "" {
+		set[kindField] = kind
+	}
+	if reason != "" {
+		set["reason"] = reason
+	}
+	return fields.SelectorFromSet(set)
+}
+
+// NewEventSource returns the event source for the given name. "auto" prefers events.k8s.io/v1 when the
+// API server serves it, falling back to core/v1 otherwise. Both APIs are backed by the same storage
+// so either one sees every Event.
+func NewEventSource(name string, dc discovery.DiscoveryInterface) (EventSource, error) {
+	switch name {
+	case EventSourceCoreV1:
+		return CoreV1EventSource{}, nil
+	case EventSourceEventsV1:
+		return EventsV1EventSource{}, nil
+	case EventSourceAuto:
+		resources, err := dc.ServerResourcesForGroupVersion(eventsv1.SchemeGroupVersion.String())
+		if errors.IsNotFound(err) {
+			return CoreV1EventSource{}, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed discovering %s: %w", eventsv1.SchemeGroupVersion, err)
+		}
+		for _, resource := range resources.APIResources {
+			if resource.Name == "events" {
+				return EventsV1EventSource{}, nil
+			}
+		}
+		return CoreV1EventSource{}, nil
+	}
+	return nil, fmt.Errorf("unknown event source %q, must be one of %s, %s or %s", name, EventSourceAuto, EventSourceCoreV1, EventSourceEventsV1)
+}
@@ -0,0 +1,187 @@
+package controller_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/clienthelpers"
+	"github.com/jukie/karpenter-deprovision-controller/pkg/controller"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+func setupEventsV1Event(nodeName string, series *eventsv1.EventSeries) *eventsv1.Event {
+	return &eventsv1.Event{
+		ObjectMeta: metav1.ObjectMeta{Name: "disruption-blocked", Namespace: "default"},
+		Regarding:  corev1.ObjectReference{Name: nodeName, Kind: controller.DisruptionBlockedEventKind},
+		Reason:     controller.DisruptionBlockedEventReason,
+		Note:       controller.DisruptionBlockedEventMessage,
+		Series:     series,
+	}
+}
+
+func TestEventSource_Convert(t *testing.T) {
+	tests := []struct {
+		name   string
+		source controller.EventSource
+		obj    client.Object
+		want   controller.DisruptionEvent
+		wantOk bool
+	}{
+		{
+			name:   "core/v1 event",
+			source: controller.CoreV1EventSource{},
+			obj:    setupDisruptionBlockedEvent("test-node", 3, nil),
+			want: controller.DisruptionEvent{
+				Kind:     controller.DisruptionBlockedEventKind,
+				NodeName: "test-node",
+				Reason:   controller.DisruptionBlockedEventReason,
+				Message:  controller.DisruptionBlockedEventMessage,
+				Count:    3,
+			},
+			wantOk: true,
+		},
+		{
+			name:   "core/v1 event with series",
+			source: controller.CoreV1EventSource{},
+			obj:    setupDisruptionBlockedEvent("test-node", 0, &corev1.EventSeries{Count: 5}),
+			want: controller.DisruptionEvent{
+				Kind:     controller.DisruptionBlockedEventKind,
+				NodeName: "test-node",
+				Reason:   controller.DisruptionBlockedEventReason,
+				Message:  controller.DisruptionBlockedEventMessage,
+				Count:    5,
+			},
+			wantOk: true,
+		},
+		{
+			name:   "events.k8s.io/v1 event",
+			source: controller.EventsV1EventSource{},
+			obj:    setupEventsV1Event("test-node", &eventsv1.EventSeries{Count: 4}),
+			want: controller.DisruptionEvent{
+				Kind:     controller.DisruptionBlockedEventKind,
+				NodeName: "test-node",
+				Reason:   controller.DisruptionBlockedEventReason,
+				Message:  controller.DisruptionBlockedEventMessage,
+				Count:    4,
+			},
+			wantOk: true,
+		},
+		{
+			name:   "events.k8s.io/v1 event read as core/v1",
+			source: controller.CoreV1EventSource{},
+			obj:    setupEventsV1Event("test-node", nil),
+			wantOk: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.source.Convert(tt.obj)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEventPredicates_EventsV1(t *testing.T) {
+	predicates := (&controller.DeprovisionController{EventSource: controller.EventsV1EventSource{}}).EventPredicates()
+
+	assert.True(t, predicates.Create(event.CreateEvent{Object: setupEventsV1Event("test-node", nil)}))
+	assert.False(t, predicates.Create(event.CreateEvent{Object: setupDisruptionBlockedEvent("test-node", 1, nil)}), "Expected core/v1 events to be ignored")
+	assert.True(t, predicates.Update(event.UpdateEvent{
+		ObjectOld: setupEventsV1Event("test-node", &eventsv1.EventSeries{Count: 2}),
+		ObjectNew: setupEventsV1Event("test-node", &eventsv1.EventSeries{Count: 3}),
+	}))
+	assert.False(t, predicates.Update(event.UpdateEvent{
+		ObjectOld: setupEventsV1Event("other-node", &eventsv1.EventSeries{Count: 3}),
+		ObjectNew: setupEventsV1Event("other-node", &eventsv1.EventSeries{Count: 3}),
+	}))
+}
+
+func TestDeprovisionController_ReconcileEventsV1(t *testing.T) {
+	pod := setupTestPod("blocking-no-sched", "testing", "test-node", map[string]string{karpv1.DoNotDisruptAnnotationKey: "true"})
+	deprovisionController := &controller.DeprovisionController{
+		Client: fake.NewClientBuilder().
+			WithRuntimeObjects(pod).
+			WithIndex(&corev1.Pod{}, "spec.nodeName", clienthelpers.PodIdxFunc).
+			WithIndex(&karpv1.NodeClaim{}, "status.nodeName", clienthelpers.NodeClaimIdxFunc).
+			Build(),
+		EventSource: controller.EventsV1EventSource{},
+	}
+
+	disruptionEvent, ok := controller.EventsV1EventSource{}.Convert(setupEventsV1Event("test-node", nil))
+	assert.True(t, ok)
+	_, err := deprovisionController.ReconcileEvent(context.TODO(), disruptionEvent)
+	assert.NoError(t, err)
+
+	updatedPod := &corev1.Pod{}
+	assert.NoError(t, deprovisionController.Client.Get(context.TODO(), client.ObjectKeyFromObject(pod), updatedPod))
+	assert.NotContains(t, updatedPod.Annotations, karpv1.DoNotDisruptAnnotationKey)
+}
+
+func TestNewEventSource(t *testing.T) {
+	tests := []struct {
+		name      string
+		source    string
+		resources []*metav1.APIResourceList
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:   "Explicit core/v1",
+			source: controller.EventSourceCoreV1,
+			want:   controller.EventSourceCoreV1,
+		},
+		{
+			name:   "Explicit events.k8s.io/v1",
+			source: controller.EventSourceEventsV1,
+			want:   controller.EventSourceEventsV1,
+		},
+		{
+			name:   "Auto with events.k8s.io/v1 served",
+			source: controller.EventSourceAuto,
+			resources: []*metav1.APIResourceList{{
+				GroupVersion: "events.k8s.io/v1",
+				APIResources: []metav1.APIResource{{Name: "events", Kind: "Event", Namespaced: true}},
+			}},
+			want: controller.EventSourceEventsV1,
+		},
+		{
+			name:   "Auto without events.k8s.io/v1",
+			source: controller.EventSourceAuto,
+			resources: []*metav1.APIResourceList{{
+				GroupVersion: "v1",
+				APIResources: []metav1.APIResource{{Name: "events", Kind: "Event", Namespaced: true}},
+			}},
+			want: controller.EventSourceCoreV1,
+		},
+		{
+			name:    "Unknown source",
+			source:  "events.k8s.io/v1beta1",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dc := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: tt.resources}}
+			source, err := controller.NewEventSource(tt.source, dc)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, source.Name())
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// evictionRecheckInterval is how soon a node is revisited after its pods were unblocked past the eviction deadline,
+	// giving the cache time to reflect the removed annotations
+	evictionRecheckInterval = time.Minute
+
+	NodeCordonedEventReason    = "CordonedForDeprovisioning"
+	PodEvictedEventReason      = "EvictedForDeprovisioning"
+	EvictionBlockedEventReason = "EvictionBlocked"
+	EvictionFailedEventReason  = "EvictionFailed"
+)
+
+// evictUnblockedPods is the escalation for expired nodes that Karpenter still hasn't disrupted EvictAfter past their
+// expiration. The node is cordoned and pods whose do-not-disrupt annotation was removed are evicted through the
+// Eviction API, so PodDisruptionBudgets are enforced by the API server. Pods are only evicted inside an active
+// disruption window and never during a blackout. justUnblocked makes sure pods unblocked in this pass are revisited.
+func (c *DeprovisionController) evictUnblockedPods(ctx context.Context, pods []corev1.Pod, node BlockedNode, justUnblocked bool) reconcile.Result {
+	if c.EvictAfter <= 0 || node.Reason != ReasonExpired || node.NodeClaim == nil || node.NodeClaim.DeletionTimestamp != nil {
+		return reconcile.Result{}
+	}
+	expiresAt, ok := ExpirationTime(node.NodeClaim)
+	if !ok {
+		return reconcile.Result{}
+	}
+
+	config := c.currentConfig()
+	keys := config.Annotations.Keys()
+	var unblocked []corev1.Pod
+	for _, pod := range pods {
+		if !keys.OriginalDoNotDisrupt.Has(pod.Annotations) || pod.DeletionTimestamp != nil || !config.Namespaces.Allows(pod.Namespace) ||
+			pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		unblocked = append(unblocked, pod)
+	}
+	if len(unblocked) == 0 && !justUnblocked {
+		return reconcile.Result{}
+	}
+
+	now := time.Now()
+	if deadline := expiresAt.Add(c.EvictAfter); now.Before(deadline) {
+		return reconcile.Result{RequeueAfter: deadline.Sub(now)}
+	}
+	if len(unblocked) == 0 {
+		return reconcile.Result{RequeueAfter: evictionRecheckInterval}
+	}
+	if blackoutName, inBlackout := c.currentBlackouts().Active(now); inBlackout {
+		log.FromContext(ctx).Info(fmt.Sprintf("Blackout %s is active, not evicting pods from node %s", blackoutName, node.Name))
+		return reconcile.Result{RequeueAfter: evictionRecheckInterval}
+	}
+
+	var evictable []corev1.Pod
+	for _, pod := range unblocked {
+		window, err := c.Resolver.ResolveWithKeys(ctx, &pod, keys)
+		if err != nil {
+			log.FromContext(ctx).Error(err, fmt.Sprintf("Failed to resolve disruption window for pod %s/%s", pod.Namespace, pod.Name))
+			continue
+		}
+		if !window.Enabled {
+			continue
+		}
+		if parsed := parseDisruptionWindow(ctx, pod.Namespace, pod.Name, window.Schedule, window.Duration, window.Timezone, config.Windows); parsed.ok && !parsed.window.ActiveAt(now) {
+			continue
+		}
+		evictable = append(evictable, pod)
+	}
+	if len(evictable) == 0 {
+		return reconcile.Result{}
+	}
+
+	if err := c.cordonNode(ctx, node, now.Sub(expiresAt)); err != nil {
+		log.FromContext(ctx).Error(err, fmt.Sprintf("Failed to cordon node %s", node.Name))
+		return reconcile.Result{RequeueAfter: c.pdbBackoff.When(node.Name)}
+	}
+
+	retry := false
+	for _, pod := range evictable {
+		if !c.evictPod(ctx, &pod, node.Name) {
+			retry = true
+		}
+	}
+	if retry {
+		requeueAfter := c.pdbBackoff.When(node.Name)
+		log.FromContext(ctx).Info(fmt.Sprintf("Requeueing node %s in %s to retry evictions", node.Name, requeueAfter))
+		return reconcile.Result{RequeueAfter: requeueAfter}
+	}
+	c.pdbBackoff.Forget(node.Name)
+	return reconcile.Result{}
+}
+
+// cordonNode marks the node unschedulable so evicted pods land elsewhere. Nodes that are already cordoned are left as is.
+func (c *DeprovisionController) cordonNode(ctx context.Context, node BlockedNode, overdue time.Duration) error {
+	n := &corev1.Node{}
+	if err := c.Client.Get(ctx, types.NamespacedName{Name: node.Name}, n); err != nil {
+		return fmt.Errorf("failed getting node %s: %w", node.Name, err)
+	}
+	if n.Spec.Unschedulable {
+		return nil
+	}
+	patch := client.RawPatch(types.MergePatchType, []byte(`{"spec":{"unschedulable":true}}`))
+	err := c.Client.Patch(ctx, n, patch)
+	if !c.DryRun {
+		metrics.PatchCounter.With(prometheus.Labels{
+			metrics.KindLabel:      "Node",
+			metrics.NamespaceLabel: "",
+			metrics.OutcomeLabel:   metrics.Outcome(err),
+		}).Inc()
+	}
+	if err != nil {
+		return fmt.Errorf("failed cordoning node %s: %w", node.Name, err)
+	}
+	log.FromContext(ctx).Info(fmt.Sprintf("Node %s expired %s ago and is still running, cordoned it to evict unblocked pods", node.Name, overdue.Round(time.Second)))
+	c.recordEvent(n, corev1.EventTypeWarning, NodeCordonedEventReason,
+		fmt.Sprintf("Cordoned node expired %s ago to evict pods whose do-not-disrupt annotation was removed", overdue.Round(time.Second)))
+	return nil
+}
+
+// evictPod evicts the pod through the Eviction API and reports whether it's gone or on its way out.
+func (c *DeprovisionController) evictPod(ctx context.Context, pod *corev1.Pod, nodeName string) bool {
+	eviction := &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace}}
+	err := c.Client.SubResource("eviction").Create(ctx, pod, eviction)
+	outcome := metrics.Outcome(err)
+	if errors.IsTooManyRequests(err) {
+		outcome = metrics.OutcomeBlocked
+	}
+	if !errors.IsNotFound(err) && !c.DryRun {
+		metrics.EvictionCounter.With(prometheus.Labels{
+			metrics.NamespaceLabel: pod.Namespace,
+			metrics.OutcomeLabel:   outcome,
+		}).Inc()
+	}
+	switch {
+	case err == nil:
+		log.FromContext(ctx).Info(fmt.Sprintf("Evicted pod %s/%s from node %s", pod.Namespace, pod.Name, nodeName))
+		c.recordEvent(pod, corev1.EventTypeNormal, PodEvictedEventReason, fmt.Sprintf("Evicted from expired node %s", nodeName))
+		return true
+	case errors.IsNotFound(err):
+		return true
+	case errors.IsTooManyRequests(err):
+		log.FromContext(ctx).Info(fmt.Sprintf("Pod disruption budget prevents evicting pod %s/%s from node %s", pod.Namespace, pod.Name, nodeName))
+		c.recordEvent(pod, corev1.EventTypeWarning, EvictionBlockedEventReason, fmt.Sprintf("Eviction from expired node %s blocked by a pod disruption budget", nodeName))
+		return false
+	default:
+		log.FromContext(ctx).Error(err, fmt.Sprintf("Failed to evict pod %s/%s from node %s", pod.Namespace, pod.Name, nodeName))
+		c.recordEvent(pod, corev1.EventTypeWarning, EvictionFailedEventReason, fmt.Sprintf("Eviction from expired node %s failed: %s", nodeName, err))
+		return false
+	}
+}
@@ -0,0 +1,139 @@
+package controller_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/clienthelpers"
+	"github.com/jukie/karpenter-deprovision-controller/pkg/controller"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+func TestHandleBlockingPods_Eviction(t *testing.T) {
+	inactiveSchedule := fmt.Sprintf("0 %d * * *", time.Now().Add(-4*time.Hour).UTC().Hour())
+
+	tests := []struct {
+		name          string
+		evictAfter    time.Duration
+		overdue       time.Duration
+		annotations   map[string]string
+		evictionError error
+		expectEvicted bool
+		expectCordon  bool
+		expectRequeue bool
+		expectEvents  []string
+	}{
+		{
+			name:          "Eviction disabled",
+			overdue:       48 * time.Hour,
+			expectEvicted: false,
+		},
+		{
+			name:          "Not stuck long enough",
+			evictAfter:    24 * time.Hour,
+			overdue:       time.Hour,
+			expectEvicted: false,
+			expectRequeue: true,
+		},
+		{
+			name:          "Stuck past eviction deadline",
+			evictAfter:    24 * time.Hour,
+			overdue:       48 * time.Hour,
+			expectEvicted: true,
+			expectCordon:  true,
+			expectEvents:  []string{controller.NodeCordonedEventReason, controller.PodEvictedEventReason},
+		},
+		{
+			name:       "Outside disruption window",
+			evictAfter: 24 * time.Hour,
+			overdue:    48 * time.Hour,
+			annotations: map[string]string{
+				controller.DisruptionWindowSchedKey:    inactiveSchedule,
+				controller.DisruptionWindowDurationKey: "3h",
+			},
+			expectEvicted: false,
+		},
+		{
+			name:          "Blocked by pod disruption budget",
+			evictAfter:    24 * time.Hour,
+			overdue:       48 * time.Hour,
+			evictionError: apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 10),
+			expectEvicted: false,
+			expectCordon:  true,
+			expectRequeue: true,
+			expectEvents:  []string{controller.NodeCordonedEventReason, controller.EvictionBlockedEventReason},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotations := map[string]string{controller.OriginalDoNotDisruptKey: "true"}
+			for k, v := range tt.annotations {
+				annotations[k] = v
+			}
+			pod := setupTestPod("unblocked", "testing", "test-node", annotations)
+			pod.Status.Phase = corev1.PodRunning
+			node := setupBlockedNode("test-node", "default", tt.overdue)
+
+			builder := fake.NewClientBuilder().
+				WithObjects(pod, setupTestNode("test-node", "default")).
+				WithIndex(&corev1.Pod{}, "spec.nodeName", clienthelpers.PodIdxFunc)
+			if tt.evictionError != nil {
+				builder = builder.WithInterceptorFuncs(interceptor.Funcs{
+					SubResourceCreate: func(ctx context.Context, c client.Client, subResourceName string, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+						return tt.evictionError
+					},
+				})
+			}
+			recorder := record.NewFakeRecorder(10)
+			deprovisionController := &controller.DeprovisionController{
+				Client:     builder.Build(),
+				EvictAfter: tt.evictAfter,
+				Recorder:   recorder,
+			}
+
+			result := deprovisionController.HandleBlockingPods(context.TODO(), []corev1.Pod{*pod}, node)
+			assert.Equal(t, tt.expectRequeue, result.RequeueAfter > 0)
+
+			err := deprovisionController.Client.Get(context.TODO(), client.ObjectKeyFromObject(pod), &corev1.Pod{})
+			assert.Equal(t, tt.expectEvicted, apierrors.IsNotFound(err), "Unexpected eviction result")
+
+			updatedNode := &corev1.Node{}
+			assert.NoError(t, deprovisionController.Client.Get(context.TODO(), types.NamespacedName{Name: "test-node"}, updatedNode))
+			assert.Equal(t, tt.expectCordon, updatedNode.Spec.Unschedulable)
+
+			close(recorder.Events)
+			var reasons []string
+			for e := range recorder.Events {
+				// FakeRecorder formats events as "<type> <reason> <message>"
+				reasons = append(reasons, strings.Fields(e)[1])
+			}
+			assert.Equal(t, tt.expectEvents, reasons)
+		})
+	}
+}
+
+func TestHandleBlockingPods_EvictionRequeueAfterUnblock(t *testing.T) {
+	pod := setupTestPod("blocking", "testing", "test-node", map[string]string{karpv1.DoNotDisruptAnnotationKey: "true"})
+	deprovisionController := &controller.DeprovisionController{
+		Client: fake.NewClientBuilder().
+			WithObjects(pod, setupTestNode("test-node", "default")).
+			Build(),
+		EvictAfter: time.Hour,
+	}
+
+	result := deprovisionController.HandleBlockingPods(context.TODO(), []corev1.Pod{*pod}, setupBlockedNode("test-node", "default", 30*time.Minute))
+	assert.InDelta(t, (30 * time.Minute).Seconds(), result.RequeueAfter.Seconds(), 5, "Expected a requeue at the eviction deadline")
+}
@@ -0,0 +1,405 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/clock"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+// PodDecision is what HandleBlockingPods decides for a pod on a node being disrupted.
+type PodDecision string
+
+const (
+	PodNotBlocking       PodDecision = "NotBlocking"
+	PodNamespaceExcluded PodDecision = "NamespaceExcluded"
+	PodInBlackout        PodDecision = "Blackout"
+	PodResolveFailed     PodDecision = "ResolveFailed"
+	PodPolicyDisabled    PodDecision = "PolicyDisabled"
+	// PodNoWindow pods have no usable schedule, so their window is always active
+	PodNoWindow                 PodDecision = "NoWindow"
+	PodWindowActive             PodDecision = "WindowActive"
+	PodWindowInactive           PodDecision = "WindowInactive"
+	PodMaxBlockDurationExceeded PodDecision = "MaxBlockDurationExceeded"
+)
+
+// Unblocks reports whether pods with this decision have their do-not-disrupt annotation removed, subject to
+// NodePool budgets, concurrency limits and PodDisruptionBudgets.
+func (d PodDecision) Unblocks() bool {
+	return d == PodNoWindow || d == PodWindowActive || d == PodMaxBlockDurationExceeded
+}
+
+// podEvaluation is how a blocking pod's disruption window was evaluated.
+type podEvaluation struct {
+	decision PodDecision
+	// blackout is the active blackout leaving the pod alone
+	blackout string
+	window   ResolvedWindow
+	// invalidSchedule is set when the schedule couldn't be parsed
+	invalidSchedule bool
+	// invalidValue describes the first invalid value of the window, e.g. schedule "0 2 * * 61", and
+	// invalidConsequence what the invalid window policy does about it
+	invalidValue       string
+	invalidConsequence string
+	// invalidPolicy is the policy that decided the window because a value was invalid
+	invalidPolicy InvalidWindowPolicy
+	// duration is the parsed window duration after applying the configured default and minimum
+	duration time.Duration
+	// windowStart and windowEnd bound the window that's currently active
+	windowStart      time.Time
+	windowEnd        time.Time
+	nextWindow       time.Time
+	maxBlockDuration time.Duration
+	maxBlockDeadline time.Time
+	err              error
+}
+
+// evaluatePod decides whether a blocking pod's do-not-disrupt annotation may be removed, without side effects
+// besides logging and counting invalid annotations.
+func (c *DeprovisionController) evaluatePod(ctx context.Context, pod *corev1.Pod, node BlockedNode, config *Config, keys AnnotationKeys, now time.Time) podEvaluation {
+	eval := podEvaluation{}
+	if pod.Annotations[karpv1.DoNotDisruptAnnotationKey] == "" {
+		eval.decision = PodNotBlocking
+		return eval
+	}
+	if !config.Namespaces.Allows(pod.Namespace) {
+		eval.decision = PodNamespaceExcluded
+		return eval
+	}
+	if name, ok := c.currentBlackouts().Active(now); ok {
+		eval.decision, eval.blackout = PodInBlackout, name
+		return eval
+	}
+	window, err := c.Resolver.ResolveWithKeys(ctx, pod, keys)
+	eval.window = window
+	if err != nil {
+		eval.decision, eval.err = PodResolveFailed, err
+		return eval
+	}
+	if !window.Enabled {
+		eval.decision = PodPolicyDisabled
+		return eval
+	}
+	// Check if configured Disruption Window is active, pods without a usable schedule are always unblocked
+	windowParse := parseDisruptionWindow(ctx, pod.Namespace, pod.Name, window.Schedule, window.Duration, window.Timezone, config.Windows)
+	eval.invalidSchedule, eval.invalidPolicy = windowParse.scheduleErr != nil, windowParse.invalidPolicy
+	eval.invalidValue, eval.invalidConsequence = windowParse.describeInvalid(window)
+	if !windowParse.ok {
+		eval.decision = PodNoWindow
+		return eval
+	}
+	parsed := windowParse.window
+	eval.duration = parsed.Duration
+	if end := parsed.ActiveUntil(now); !end.IsZero() {
+		eval.decision, eval.windowStart, eval.windowEnd = PodWindowActive, end.Add(-parsed.Duration), end
+		return eval
+	}
+	eval.nextWindow = parsed.Schedule.Next(now)
+	// Pods that have blocked the node for too long are unblocked regardless of their window
+	eval.maxBlockDuration, eval.maxBlockDeadline = c.maxBlockDeadline(ctx, pod, node, now)
+	if eval.maxBlockDuration > 0 && !now.Before(eval.maxBlockDeadline) {
+		eval.decision = PodMaxBlockDurationExceeded
+		return eval
+	}
+	eval.decision = PodWindowInactive
+	return eval
+}
+
+// Explanation traces how HandleBlockingPods treats a node, see Explain.
+type Explanation struct {
+	Node string `json:"node"`
+	// NodeFound is false when the node no longer exists, e.g. because it was already disrupted
+	NodeFound bool   `json:"nodeFound"`
+	NodeClaim string `json:"nodeClaim,omitempty"`
+	NodePool  string `json:"nodePool,omitempty"`
+	// Reason is why the node is being disrupted, empty when Karpenter isn't disrupting it
+	Reason DisruptionReason `json:"reason,omitempty"`
+	// ReasonUnblocks is whether the reason is configured for unblocking
+	ReasonUnblocks bool             `json:"reasonUnblocks"`
+	DisruptedSince *metav1.Time     `json:"disruptedSince,omitempty"`
+	Events         []ExplainedEvent `json:"events"`
+	Blackout       string           `json:"blackout,omitempty"`
+	Pods           []PodExplanation `json:"pods"`
+	// BudgetsAllow is whether the NodePool disruption budgets allow disrupting the node, only checked when pods would be unblocked
+	BudgetsAllow *bool    `json:"budgetsAllow,omitempty"`
+	Verdict      string   `json:"verdict"`
+	Notes        []string `json:"notes,omitempty"`
+}
+
+// ExplainedEvent is an Event about the node that matched an event matcher.
+type ExplainedEvent struct {
+	Namespace string       `json:"namespace"`
+	Name      string       `json:"name"`
+	Reason    string       `json:"reason"`
+	Message   string       `json:"message"`
+	Count     int32        `json:"count"`
+	LastSeen  *metav1.Time `json:"lastSeen,omitempty"`
+	Matcher   string       `json:"matcher"`
+}
+
+// PodExplanation is the evaluation of a blocking pod on the node.
+type PodExplanation struct {
+	Namespace string      `json:"namespace"`
+	Name      string      `json:"name"`
+	Decision  PodDecision `json:"decision"`
+	Blackout  string      `json:"blackout,omitempty"`
+	// Policy is the DisruptionPolicy that supplied the window, Schedule, Duration and Timezone are the resolved values
+	Policy   string `json:"policy,omitempty"`
+	Schedule string `json:"schedule,omitempty"`
+	Duration string `json:"duration,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
+	// InvalidSchedule is set when the schedule couldn't be parsed
+	InvalidSchedule bool `json:"invalidSchedule,omitempty"`
+	// InvalidWindow describes the first invalid value of the window and how the invalid window policy treats it
+	InvalidWindow string `json:"invalidWindow,omitempty"`
+	// WindowDuration is the duration in effect after applying the configured default and minimum
+	WindowDuration   *metav1.Duration `json:"windowDuration,omitempty"`
+	WindowStart      *metav1.Time     `json:"windowStart,omitempty"`
+	WindowEnd        *metav1.Time     `json:"windowEnd,omitempty"`
+	NextWindow       *metav1.Time     `json:"nextWindow,omitempty"`
+	MaxBlockDuration *metav1.Duration `json:"maxBlockDuration,omitempty"`
+	MaxBlockDeadline *metav1.Time     `json:"maxBlockDeadline,omitempty"`
+	// HeldByPDB names the PodDisruptionBudget that leaves no disruptions for the pod
+	HeldByPDB string `json:"heldByPDB,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func newPodExplanation(pod *corev1.Pod, eval podEvaluation) PodExplanation {
+	p := PodExplanation{
+		Namespace:        pod.Namespace,
+		Name:             pod.Name,
+		Decision:         eval.decision,
+		Blackout:         eval.blackout,
+		Policy:           eval.window.Policy,
+		Schedule:         eval.window.Schedule,
+		Duration:         eval.window.Duration,
+		Timezone:         eval.window.Timezone,
+		InvalidSchedule:  eval.invalidSchedule,
+		WindowDuration:   optionalDuration(eval.duration),
+		WindowStart:      optionalTime(eval.windowStart),
+		WindowEnd:        optionalTime(eval.windowEnd),
+		NextWindow:       optionalTime(eval.nextWindow),
+		MaxBlockDuration: optionalDuration(eval.maxBlockDuration),
+		MaxBlockDeadline: optionalTime(eval.maxBlockDeadline),
+	}
+	if eval.invalidValue != "" {
+		p.InvalidWindow = fmt.Sprintf("%s is invalid, %s", eval.invalidValue, eval.invalidConsequence)
+	}
+	if eval.err != nil {
+		p.Error = eval.err.Error()
+	}
+	return p
+}
+
+// optionalTime leaves zero times out of the JSON output.
+func optionalTime(t time.Time) *metav1.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &metav1.Time{Time: t}
+}
+
+func optionalDuration(d time.Duration) *metav1.Duration {
+	if d == 0 {
+		return nil
+	}
+	return &metav1.Duration{Duration: d}
+}
+
+// Explain evaluates the node the way a reconcile would, without changing anything, and traces every decision.
+// Pods are read from the API server rather than the cache, so c.Client may be a plain client. Concurrency limits
+// are tracked in the memory of the running controller and aren't evaluated.
+func (c *DeprovisionController) Explain(ctx context.Context, nodeName string, now time.Time) (*Explanation, error) {
+	explanation := &Explanation{Node: nodeName, Events: []ExplainedEvent{}, Pods: []PodExplanation{}}
+	config := c.currentConfig()
+	keys := config.Annotations.Keys()
+
+	if err := c.Client.Get(ctx, types.NamespacedName{Name: nodeName}, &corev1.Node{}); err == nil {
+		explanation.NodeFound = true
+	} else if !errors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed getting node %s: %w", nodeName, err)
+	}
+	var podList corev1.PodList
+	if err := c.Client.List(ctx, &podList, client.MatchingFields{"spec.nodeName": nodeName}); err != nil {
+		return nil, fmt.Errorf("failed getting pods on node %s: %w", nodeName, err)
+	}
+	nc, err := c.nodeClaimFor(ctx, nodeName)
+	if err != nil {
+		return nil, err
+	}
+	if explanation.Events, err = c.matchedEvents(ctx, nodeName, config.EventMatchers); err != nil {
+		return nil, err
+	}
+
+	// Mirror blockedNode, DisruptionBlocked events are ReasonBlocked when the NodeClaim gives no reason, or expiration
+	// without a NodeClaim
+	node := BlockedNode{Name: nodeName, NodeClaim: nc}
+	if nc != nil {
+		explanation.NodeClaim = nc.Name
+		explanation.NodePool = node.NodePool()
+		if reason, ok := DisruptionReasonFor(nc, podList.Items, now); ok {
+			node.Reason = reason
+		}
+	}
+	if node.Reason == "" && len(explanation.Events) > 0 {
+		node.Reason = ReasonExpired
+		if nc != nil {
+			node.Reason = ReasonBlocked
+		}
+	}
+	explanation.Reason = node.Reason
+	if node.Reason == "" {
+		explanation.Verdict = "Karpenter isn't disrupting the node, there's nothing to unblock"
+		return explanation, nil
+	}
+	explanation.DisruptedSince = optionalTime(node.DisruptedSince(now))
+	explanation.ReasonUnblocks = c.reasonPolicy(node.Reason).AllowUnblock
+	explanation.Blackout, _ = c.currentBlackouts().Active(now)
+
+	budget := newPDBBudget(c.Client, keys.OriginalDoNotDisrupt)
+	blocking, unblocking, held := 0, 0, 0
+	var nextWindow time.Time
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		eval := c.evaluatePod(ctx, pod, node, config, keys, now)
+		if eval.decision == PodNotBlocking {
+			continue
+		}
+		blocking++
+		podExplanation := newPodExplanation(pod, eval)
+		if eval.decision.Unblocks() {
+			pdbName, allowed, err := budget.reserve(ctx, pod)
+			if err != nil {
+				return nil, err
+			}
+			if allowed {
+				unblocking++
+			} else {
+				podExplanation.HeldByPDB = pdbName
+				held++
+			}
+		}
+		if eval.decision == PodWindowInactive {
+			nextWindow = earliest(nextWindow, eval.nextWindow)
+		}
+		explanation.Pods = append(explanation.Pods, podExplanation)
+	}
+	sort.Slice(explanation.Pods, func(i, j int) bool {
+		a, b := explanation.Pods[i], explanation.Pods[j]
+		return a.Namespace < b.Namespace || (a.Namespace == b.Namespace && a.Name < b.Name)
+	})
+
+	switch {
+	case !explanation.ReasonUnblocks:
+		explanation.Verdict = fmt.Sprintf("The node %s, but %s is not configured for unblocking", node.Reason.description(), node.Reason)
+		return explanation, nil
+	case blocking == 0:
+		explanation.Verdict = "No pods on the node have a do-not-disrupt annotation"
+		return explanation, nil
+	case explanation.Blackout != "":
+		explanation.Verdict = fmt.Sprintf("Blackout %s is active, no annotations are removed until it ends", explanation.Blackout)
+		return explanation, nil
+	}
+	if unblocking > 0 {
+		allowed, err := c.nodePoolAllowsDisruption(ctx, node, clock.RealClock{})
+		if err != nil {
+			return nil, err
+		}
+		explanation.BudgetsAllow = &allowed
+		if !allowed {
+			explanation.Verdict = fmt.Sprintf("Nodepool %s disruption budgets don't allow disrupting the node for reason %s", node.NodePool(), node.Reason)
+			return explanation, nil
+		}
+	}
+	explanation.Verdict = fmt.Sprintf("%d of %d blocking pods would be unblocked now", unblocking, blocking)
+	if held > 0 {
+		explanation.Verdict += fmt.Sprintf(", %d held back by pod disruption budgets", held)
+	}
+	if !nextWindow.IsZero() {
+		explanation.Verdict += fmt.Sprintf(", the next disruption window opens at %s", nextWindow.Format(time.RFC3339))
+	}
+	if unblocking > 0 && (config.Concurrency.MaxUnblockedNodes > 0 || config.Concurrency.MaxUnblockedNodesPerNodePool > 0) {
+		explanation.Notes = append(explanation.Notes, "Concurrency limits are configured and may delay unblocking, they depend on the state of the running controller")
+	}
+	return explanation, nil
+}
+
+// ExplainPod explains the node the pod runs on, limited to the pod itself.
+func (c *DeprovisionController) ExplainPod(ctx context.Context, key types.NamespacedName, now time.Time) (*Explanation, error) {
+	pod := &corev1.Pod{}
+	if err := c.Client.Get(ctx, key, pod); err != nil {
+		return nil, fmt.Errorf("failed getting pod %s: %w", key, err)
+	}
+	if pod.Spec.NodeName == "" {
+		return nil, fmt.Errorf("pod %s isn't scheduled to a node", key)
+	}
+	explanation, err := c.Explain(ctx, pod.Spec.NodeName, now)
+	if err != nil {
+		return nil, err
+	}
+	pods := []PodExplanation{}
+	for _, p := range explanation.Pods {
+		if p.Namespace == key.Namespace && p.Name == key.Name {
+			pods = append(pods, p)
+		}
+	}
+	if len(pods) == 0 && explanation.Reason != "" {
+		explanation.Notes = append(explanation.Notes, fmt.Sprintf("Pod %s has no do-not-disrupt annotation and doesn't block the node", key))
+	}
+	explanation.Pods = pods
+	return explanation, nil
+}
+
+// nodeClaimFor finds the NodeClaim behind the node by listing them all, so it doesn't depend on a cache index.
+func (c *DeprovisionController) nodeClaimFor(ctx context.Context, nodeName string) (*karpv1.NodeClaim, error) {
+	var ncList karpv1.NodeClaimList
+	if err := c.Client.List(ctx, &ncList); err != nil {
+		return nil, fmt.Errorf("failed getting nodeclaims: %w", err)
+	}
+	for i := range ncList.Items {
+		if ncList.Items[i].Status.NodeName == nodeName {
+			return &ncList.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// matchedEvents returns the Events about the node that match an event matcher. core/v1 is read regardless of the
+// configured EventSource since both Event APIs serve the same Events.
+func (c *DeprovisionController) matchedEvents(ctx context.Context, nodeName string, matchers EventMatchers) ([]ExplainedEvent, error) {
+	var eventList corev1.EventList
+	if err := c.Client.List(ctx, &eventList, client.MatchingFields{"involvedObject.name": nodeName}); err != nil {
+		return nil, fmt.Errorf("failed getting events for node %s: %w", nodeName, err)
+	}
+	events := []ExplainedEvent{}
+	for i := range eventList.Items {
+		e := &eventList.Items[i]
+		disruptionEvent, _ := CoreV1EventSource{}.Convert(e)
+		matcher, ok := matchers.Match(disruptionEvent)
+		if !ok {
+			continue
+		}
+		lastSeen := e.LastTimestamp.Time
+		if e.Series != nil && e.Series.LastObservedTime.After(lastSeen) {
+			lastSeen = e.Series.LastObservedTime.Time
+		}
+		events = append(events, ExplainedEvent{
+			Namespace: e.Namespace,
+			Name:      e.Name,
+			Reason:    e.Reason,
+			Message:   e.Message,
+			Count:     disruptionEvent.Count,
+			LastSeen:  optionalTime(lastSeen),
+			Matcher:   matcher,
+		})
+	}
+	return events, nil
+}
@@ -0,0 +1,163 @@
+package controller_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/clienthelpers"
+	"github.com/jukie/karpenter-deprovision-controller/pkg/controller"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+func newExplainController(objList ...runtime.Object) *controller.DeprovisionController {
+	return &controller.DeprovisionController{Client: fake.NewClientBuilder().
+		WithRuntimeObjects(objList...).
+		WithIndex(&corev1.Pod{}, "spec.nodeName", clienthelpers.PodIdxFunc).
+		WithIndex(&corev1.Event{}, "involvedObject.name", func(o client.Object) []string {
+			return []string{o.(*corev1.Event).InvolvedObject.Name}
+		}).
+		Build()}
+}
+
+func TestExplain(t *testing.T) {
+	now := time.Now()
+	activeSchedule := fmt.Sprintf("%d %d * * *", now.UTC().Minute(), now.UTC().Hour())
+	inactiveSchedule := fmt.Sprintf("%d %d * * *", now.UTC().Minute(), now.Add(-4*time.Hour).UTC().Hour())
+
+	nc := setupExpiredNodeClaim("test-node", "default")
+	nc.Name = "test-nodeclaim"
+	event := setupDisruptionBlockedEvent("test-node", 3, nil)
+	event.ObjectMeta = metav1.ObjectMeta{Name: "blocked", Namespace: "default"}
+	deprovisionController := newExplainController(
+		setupTestNode("test-node", "default"),
+		nc,
+		event,
+		setupTestPod("active", "testing", "test-node", map[string]string{
+			karpv1.DoNotDisruptAnnotationKey:    "true",
+			controller.DisruptionWindowSchedKey: activeSchedule,
+		}),
+		setupTestPod("inactive", "testing", "test-node", map[string]string{
+			karpv1.DoNotDisruptAnnotationKey:    "true",
+			controller.DisruptionWindowSchedKey: inactiveSchedule,
+		}),
+		setupTestPod("invalid", "testing", "test-node", map[string]string{
+			karpv1.DoNotDisruptAnnotationKey:    "true",
+			controller.DisruptionWindowSchedKey: "not a schedule",
+		}),
+		setupTestPod("not-blocking", "testing", "test-node", nil),
+	)
+	explanation, err := deprovisionController.Explain(context.TODO(), "test-node", now)
+	assert.NoError(t, err)
+	assert.True(t, explanation.NodeFound)
+	assert.Equal(t, "test-nodeclaim", explanation.NodeClaim)
+	assert.Equal(t, "default", explanation.NodePool)
+	assert.Equal(t, controller.ReasonExpired, explanation.Reason)
+	assert.True(t, explanation.ReasonUnblocks)
+	if assert.Len(t, explanation.Events, 1) {
+		assert.Equal(t, int32(3), explanation.Events[0].Count)
+	}
+
+	decisions := map[string]controller.PodExplanation{}
+	for _, pod := range explanation.Pods {
+		decisions[pod.Name] = pod
+	}
+	assert.Len(t, decisions, 3, "Expected only blocking pods to be explained")
+	assert.Equal(t, controller.PodWindowActive, decisions["active"].Decision)
+	assert.NotNil(t, decisions["active"].WindowEnd)
+	assert.Equal(t, controller.PodWindowInactive, decisions["inactive"].Decision)
+	assert.NotNil(t, decisions["inactive"].NextWindow)
+	assert.Equal(t, controller.PodNoWindow, decisions["invalid"].Decision)
+	assert.True(t, decisions["invalid"].InvalidSchedule)
+	assert.Contains(t, explanation.Verdict, "2 of 3 blocking pods would be unblocked now")
+
+	// Nothing was changed
+	pod := &corev1.Pod{}
+	assert.NoError(t, deprovisionController.Client.Get(context.TODO(), types.NamespacedName{Name: "active", Namespace: "testing"}, pod))
+	assert.Equal(t, "true", pod.Annotations[karpv1.DoNotDisruptAnnotationKey])
+}
+
+func TestExplain_Verdicts(t *testing.T) {
+	blockingPod := setupTestPod("blocking", "testing", "test-node", map[string]string{karpv1.DoNotDisruptAnnotationKey: "true"})
+
+	tests := []struct {
+		name       string
+		objList    []runtime.Object
+		controller func(c *controller.DeprovisionController)
+		verdict    string
+	}{
+		{
+			name:    "Not disrupted",
+			objList: []runtime.Object{setupTestNodeClaim("test-node", time.Hour, "2h"), blockingPod},
+			verdict: "Karpenter isn't disrupting the node",
+		},
+		{
+			name:    "Reason not configured",
+			objList: []runtime.Object{setupTestNodeClaim("test-node", time.Hour, "Never", karpv1.ConditionTypeDrifted), blockingPod},
+			controller: func(c *controller.DeprovisionController) {
+				c.ReasonPolicies = map[controller.DisruptionReason]controller.ReasonPolicy{controller.ReasonExpired: {AllowUnblock: true}}
+			},
+			verdict: "Drifted is not configured for unblocking",
+		},
+		{
+			name:    "No blocking pods",
+			objList: []runtime.Object{setupTestNodeClaim("test-node", 3*time.Hour, "2h")},
+			verdict: "No pods on the node have a do-not-disrupt annotation",
+		},
+		{
+			name:    "Blackout",
+			objList: []runtime.Object{setupTestNodeClaim("test-node", 3*time.Hour, "2h"), blockingPod},
+			controller: func(c *controller.DeprovisionController) {
+				c.Blackouts = activeBlackout(t)
+			},
+			verdict: "Blackout freeze is active",
+		},
+		{
+			name:    "Unblocked",
+			objList: []runtime.Object{setupTestNodeClaim("test-node", 3*time.Hour, "2h"), blockingPod},
+			verdict: "1 of 1 blocking pods would be unblocked now",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deprovisionController := newExplainController(tt.objList...)
+			if tt.controller != nil {
+				tt.controller(deprovisionController)
+			}
+			explanation, err := deprovisionController.Explain(context.TODO(), "test-node", time.Now())
+			assert.NoError(t, err)
+			assert.False(t, explanation.NodeFound)
+			assert.Contains(t, explanation.Verdict, tt.verdict)
+		})
+	}
+}
+
+func TestExplainPod(t *testing.T) {
+	deprovisionController := newExplainController(
+		setupTestNodeClaim("test-node", 3*time.Hour, "2h"),
+		setupTestPod("blocking", "testing", "test-node", map[string]string{karpv1.DoNotDisruptAnnotationKey: "true"}),
+		setupTestPod("other", "testing", "test-node", map[string]string{karpv1.DoNotDisruptAnnotationKey: "true"}),
+		setupTestPod("unscheduled", "testing", "", nil),
+	)
+
+	explanation, err := deprovisionController.ExplainPod(context.TODO(), types.NamespacedName{Name: "blocking", Namespace: "testing"}, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, "test-node", explanation.Node)
+	if assert.Len(t, explanation.Pods, 1) {
+		assert.Equal(t, "blocking", explanation.Pods[0].Name)
+		assert.Equal(t, controller.PodNoWindow, explanation.Pods[0].Decision)
+	}
+
+	_, err = deprovisionController.ExplainPod(context.TODO(), types.NamespacedName{Name: "unscheduled", Namespace: "testing"}, time.Now())
+	assert.Error(t, err)
+}
@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+
+	"sigs.k8s.io/yaml"
+)
+
+// EventMatcher is a rule recognizing events Karpenter emits when pods block a node's disruption.
+// Empty fields match anything.
+type EventMatcher struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason,omitempty"`
+	// Kind is the kind of the object the event is about, only Node events name a node that can be unblocked.
+	Kind string `json:"kind,omitempty"`
+	// Message is a regular expression matched against the event message.
+	Message string `json:"message,omitempty"`
+
+	message *regexp.Regexp
+}
+
+// EventMatchers is an ordered list of rules, an event matches when any rule does.
+type EventMatchers []EventMatcher
+
+// BuiltinEventMatchers are the DisruptionBlocked events emitted by known Karpenter releases, keyed by API version.
+// Releases before v1.0 (v1beta1) aren't listed since their clusters don't serve the v1 NodeClaims the controller reads.
+var BuiltinEventMatchers = map[string]EventMatchers{
+	// Karpenter v1.0 and later
+	"v1": {
+		{Name: "marked-for-deletion", Reason: DisruptionBlockedEventReason, Kind: DisruptionBlockedEventKind, Message: "^" + regexp.QuoteMeta(DisruptionBlockedEventMessage) + "$"},
+		{Name: "pod-do-not-disrupt", Reason: DisruptionBlockedEventReason, Kind: DisruptionBlockedEventKind, Message: `^Cannot disrupt Node: [Pp]od "[^"]+" has "karpenter\.sh/do-not-disrupt" annotation$`},
+	},
+}
+
+// DefaultEventMatchers matches the events of every known Karpenter release so upgrades keep working.
+var DefaultEventMatchers = mustCompile(allBuiltinEventMatchers())
+
+// BuiltinEventMatchersFor returns the built-in rules for a Karpenter API version, or for every known version when empty.
+func BuiltinEventMatchersFor(version string) (EventMatchers, error) {
+	if version == "" {
+		return DefaultEventMatchers, nil
+	}
+	matchers, ok := BuiltinEventMatchers[version]
+	if !ok {
+		versions := make([]string, 0, len(BuiltinEventMatchers))
+		for v := range BuiltinEventMatchers {
+			versions = append(versions, v)
+		}
+		sort.Strings(versions)
+		return nil, fmt.Errorf("unknown Karpenter version %q, must be one of %v", version, versions)
+	}
+	return matchers.Compile()
+}
+
+// LoadEventMatchers reads rules from a YAML file of the form:
+//
+//	matchers:
+//	  - name: pod-do-not-disrupt
+//	    reason: DisruptionBlocked
+//	    kind: Node
+//	    message: '^Cannot disrupt Node: pod ".+" has "karpenter\.sh/do-not-disrupt" annotation$'
+func LoadEventMatchers(path string) (EventMatchers, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading event matchers %s: %w", path, err)
+	}
+	var file struct {
+		Matchers EventMatchers `json:"matchers"`
+	}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("failed parsing event matchers %s: %w", path, err)
+	}
+	if len(file.Matchers) == 0 {
+		return nil, fmt.Errorf("event matchers %s define no matchers", path)
+	}
+	return file.Matchers.Compile()
+}
+
+// Compile validates the rules and compiles their message expressions.
+func (m EventMatchers) Compile() (EventMatchers, error) {
+	compiled := make(EventMatchers, len(m))
+	names := map[string]bool{}
+	for i, matcher := range m {
+		if matcher.Name == "" {
+			return nil, fmt.Errorf("event matcher %d has no name", i)
+		}
+		if names[matcher.Name] {
+			return nil, fmt.Errorf("duplicate event matcher %q", matcher.Name)
+		}
+		names[matcher.Name] = true
+		if matcher.Message != "" {
+			re, err := regexp.Compile(matcher.Message)
+			if err != nil {
+				return nil, fmt.Errorf("invalid message expression for event matcher %q: %w", matcher.Name, err)
+			}
+			matcher.message = re
+		}
+		compiled[i] = matcher
+	}
+	return compiled, nil
+}
+
+// Match returns the name of the first rule matching the event.
+func (m EventMatchers) Match(e DisruptionEvent) (string, bool) {
+	for _, matcher := range m {
+		if matcher.Reason != "" && matcher.Reason != e.Reason {
+			continue
+		}
+		if matcher.Kind != "" && matcher.Kind != e.Kind {
+			continue
+		}
+		if matcher.message != nil && !matcher.message.MatchString(e.Message) {
+			continue
+		}
+		return matcher.Name, true
+	}
+	return "", false
+}
+
+// CommonFields returns the kind and reason shared by every rule, empty when the rules disagree.
+// They're used to narrow the event cache since field selectors can't express alternatives.
+func (m EventMatchers) CommonFields() (kind, reason string) {
+	for i, matcher := range m {
+		if i == 0 {
+			kind, reason = matcher.Kind, matcher.Reason
+			continue
+		}
+		if matcher.Kind != kind {
+			kind = ""
+		}
+		if matcher.Reason != reason {
+			reason = ""
+		}
+	}
+	return kind, reason
+}
+
+// allBuiltinEventMatchers merges the built-in rules of every version, dropping rules shared between versions.
+func allBuiltinEventMatchers() EventMatchers {
+	versions := make([]string, 0, len(BuiltinEventMatchers))
+	for v := range BuiltinEventMatchers {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+
+	var all EventMatchers
+	seen := map[string]bool{}
+	for _, v := range versions {
+		for _, matcher := range BuiltinEventMatchers[v] {
+			if !seen[matcher.Name] {
+				seen[matcher.Name] = true
+				all = append(all, matcher)
+			}
+		}
+	}
+	return all
+}
+
+func mustCompile(m EventMatchers) EventMatchers {
+	compiled, err := m.Compile()
+	if err != nil {
+		panic(err)
+	}
+	return compiled
+}
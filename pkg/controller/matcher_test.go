@@ -0,0 +1,144 @@
+package controller_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/controller"
+	"github.com/stretchr/testify/assert"
+
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+const podBlockedMessage = `Cannot disrupt Node: pod "testing/blocking" has "karpenter.sh/do-not-disrupt" annotation`
+
+func TestBuiltinEventMatchersFor(t *testing.T) {
+	markedForDeletion := controller.DisruptionEvent{
+		Kind:    controller.DisruptionBlockedEventKind,
+		Reason:  controller.DisruptionBlockedEventReason,
+		Message: controller.DisruptionBlockedEventMessage,
+	}
+	podBlocked := markedForDeletion
+	podBlocked.Message = podBlockedMessage
+	nodeClaimBlocked := podBlocked
+	nodeClaimBlocked.Kind = "NodeClaim"
+
+	tests := []struct {
+		name    string
+		version string
+		event   controller.DisruptionEvent
+		want    bool
+	}{
+		{name: "v1 marked for deletion", version: "v1", event: markedForDeletion, want: true},
+		{name: "v1 pod annotation", version: "v1", event: podBlocked, want: true},
+		{name: "v1 ignores NodeClaim events", version: "v1", event: nodeClaimBlocked, want: false},
+		{name: "All versions by default", version: "", event: podBlocked, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matchers, err := controller.BuiltinEventMatchersFor(tt.version)
+			assert.NoError(t, err)
+			_, ok := matchers.Match(tt.event)
+			assert.Equal(t, tt.want, ok)
+		})
+	}
+
+	_, err := controller.BuiltinEventMatchersFor("v1alpha5")
+	assert.Error(t, err)
+	_, err = controller.BuiltinEventMatchersFor("v1beta1")
+	assert.Error(t, err, "Expected v1beta1 to be rejected since the controller needs v1 NodeClaims")
+}
+
+func TestLoadEventMatchers(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name: "Valid",
+			content: `matchers:
+  - name: custom
+    reason: DisruptionBlocked
+    kind: Node
+    message: '^Cannot disrupt Node: .*do-not-disrupt'
+`,
+		},
+		{
+			name: "Invalid expression",
+			content: `matchers:
+  - name: custom
+    message: '(unclosed'
+`,
+			wantErr: true,
+		},
+		{
+			name: "Missing name",
+			content: `matchers:
+  - reason: DisruptionBlocked
+`,
+			wantErr: true,
+		},
+		{
+			name: "Unknown field",
+			content: `matchers:
+  - name: custom
+    involvedObject: Node
+`,
+			wantErr: true,
+		},
+		{
+			name:    "No matchers",
+			content: "matchers: []\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "matchers.yaml")
+			assert.NoError(t, os.WriteFile(path, []byte(tt.content), 0o600))
+			matchers, err := controller.LoadEventMatchers(path)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			name, ok := matchers.Match(controller.DisruptionEvent{
+				Kind:    "Node",
+				Reason:  "DisruptionBlocked",
+				Message: podBlockedMessage,
+			})
+			assert.True(t, ok)
+			assert.Equal(t, "custom", name)
+		})
+	}
+}
+
+func TestEventMatchers_CommonFields(t *testing.T) {
+	kind, reason := controller.DefaultEventMatchers.CommonFields()
+	assert.Equal(t, controller.DisruptionBlockedEventKind, kind)
+	assert.Equal(t, controller.DisruptionBlockedEventReason, reason)
+
+	kind, reason = controller.EventMatchers{
+		{Name: "a", Kind: "Node", Reason: "DisruptionBlocked"},
+		{Name: "b", Kind: "Node", Reason: "Unconsolidatable"},
+	}.CommonFields()
+	assert.Equal(t, "Node", kind)
+	assert.Empty(t, reason)
+}
+
+func TestEventPredicates_Matchers(t *testing.T) {
+	podBlockedEvent := setupDisruptionBlockedEvent("test-node", 1, nil)
+	podBlockedEvent.Message = podBlockedMessage
+
+	markedForDeletion, err := controller.EventMatchers{{Name: "marked-for-deletion", Message: controller.DisruptionBlockedEventMessage}}.Compile()
+	assert.NoError(t, err)
+	predicates := (&controller.DeprovisionController{EventMatchers: markedForDeletion}).EventPredicates()
+	assert.False(t, predicates.Create(event.CreateEvent{Object: podBlockedEvent}))
+
+	predicates = (&controller.DeprovisionController{}).EventPredicates()
+	assert.True(t, predicates.Create(event.CreateEvent{Object: podBlockedEvent}))
+}
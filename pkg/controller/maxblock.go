@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// MaxBlockDurationKey shortens DeprovisionController.MaxBlockDuration for a single pod
+	MaxBlockDurationKey = DefaultAnnotationPrefix + "/" + MaxBlockDurationName
+
+	MaxBlockDurationExceededEventReason = "MaxBlockDurationExceeded"
+)
+
+// maxBlockDuration returns how long the pod may keep its node from being disrupted, 0 meaning forever.
+// The pod annotation can only shorten the global setting, it's the platform's hard deadline. Annotations of 0 and
+// invalid ones fall back to the global setting, and without one the annotation applies as is.
+func (c *DeprovisionController) maxBlockDuration(ctx context.Context, pod *corev1.Pod) time.Duration {
+	value, ok := readAnnotation(pod, c.currentConfig().Annotations.Keys().MaxBlockDuration)
+	if !ok {
+		return c.MaxBlockDuration
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		log.FromContext(ctx).Error(err, fmt.Sprintf("Invalid max block duration for pod %s/%s, using %s", pod.Namespace, pod.Name, c.MaxBlockDuration))
+		metrics.FailedAnnotationParseCounter.With(prometheus.Labels{
+			metrics.AnnotationType: "MaxBlockDuration",
+			metrics.NamespaceLabel: pod.Namespace,
+		}).Inc()
+		return c.MaxBlockDuration
+	}
+	if duration == 0 || (c.MaxBlockDuration > 0 && duration > c.MaxBlockDuration) {
+		return c.MaxBlockDuration
+	}
+	return duration
+}
+
+// maxBlockDeadline returns the pod's max block duration and when the node will have been disrupted for that long,
+// after which the pod's disruption window no longer applies. The duration is 0 when the pod may block forever.
+func (c *DeprovisionController) maxBlockDeadline(ctx context.Context, pod *corev1.Pod, node BlockedNode, now time.Time) (time.Duration, time.Time) {
+	limit := c.maxBlockDuration(ctx, pod)
+	if limit <= 0 {
+		return 0, time.Time{}
+	}
+	return limit, node.DisruptedSince(now).Add(limit)
+}
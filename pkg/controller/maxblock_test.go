@@ -0,0 +1,139 @@
+package controller_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/blackout"
+	"github.com/jukie/karpenter-deprovision-controller/pkg/controller"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+func activeBlackout(t *testing.T) *blackout.Calendar {
+	calendar, err := blackout.Parse([]byte(fmt.Sprintf("dates:\n  - name: freeze\n    start: %s\n    end: %s\n",
+		time.Now().Add(-time.Hour).UTC().Format(time.RFC3339), time.Now().Add(time.Hour).UTC().Format(time.RFC3339))))
+	assert.NoError(t, err)
+	return calendar
+}
+
+func TestHandleBlockingPods_MaxBlockDuration(t *testing.T) {
+	// February 30th never comes around so the window never opens
+	const neverSchedule = "0 0 30 2 *"
+
+	tests := []struct {
+		name             string
+		maxBlockDuration time.Duration
+		annotation       string
+		overdue          time.Duration
+		blackouts        *blackout.Calendar
+		expectRemoved    bool
+	}{
+		{
+			name:          "No max block duration",
+			overdue:       30 * 24 * time.Hour,
+			expectRemoved: false,
+		},
+		{
+			name:             "Global max block duration exceeded",
+			maxBlockDuration: 7 * 24 * time.Hour,
+			overdue:          8 * 24 * time.Hour,
+			expectRemoved:    true,
+		},
+		{
+			name:             "Global max block duration not exceeded",
+			maxBlockDuration: 7 * 24 * time.Hour,
+			overdue:          6 * 24 * time.Hour,
+			expectRemoved:    false,
+		},
+		{
+			name:          "Annotation max block duration exceeded",
+			annotation:    "24h",
+			overdue:       48 * time.Hour,
+			expectRemoved: true,
+		},
+		{
+			name:             "Annotation can't extend global setting",
+			maxBlockDuration: 24 * time.Hour,
+			annotation:       "72h",
+			overdue:          48 * time.Hour,
+			expectRemoved:    true,
+		},
+		{
+			name:             "Annotation shortens global setting",
+			maxBlockDuration: 72 * time.Hour,
+			annotation:       "24h",
+			overdue:          48 * time.Hour,
+			expectRemoved:    true,
+		},
+		{
+			name:             "Zero annotation uses global setting",
+			maxBlockDuration: 24 * time.Hour,
+			annotation:       "0",
+			overdue:          48 * time.Hour,
+			expectRemoved:    true,
+		},
+		{
+			name:          "Zero annotation without global setting blocks forever",
+			annotation:    "0s",
+			overdue:       30 * 24 * time.Hour,
+			expectRemoved: false,
+		},
+		{
+			name:             "Invalid annotation falls back to global setting",
+			maxBlockDuration: time.Hour,
+			annotation:       "two days",
+			overdue:          48 * time.Hour,
+			expectRemoved:    true,
+		},
+		{
+			name:             "Blackouts still apply",
+			maxBlockDuration: time.Hour,
+			overdue:          48 * time.Hour,
+			blackouts:        activeBlackout(t),
+			expectRemoved:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotations := map[string]string{
+				karpv1.DoNotDisruptAnnotationKey:    "true",
+				controller.DisruptionWindowSchedKey: neverSchedule,
+			}
+			if tt.annotation != "" {
+				annotations[controller.MaxBlockDurationKey] = tt.annotation
+			}
+			pod := setupTestPod("blocking-never-sched", "testing", "test-node", annotations)
+			recorder := record.NewFakeRecorder(10)
+			deprovisionController := &controller.DeprovisionController{
+				Client:           fake.NewClientBuilder().WithRuntimeObjects(pod).Build(),
+				MaxBlockDuration: tt.maxBlockDuration,
+				Blackouts:        tt.blackouts,
+				Recorder:         recorder,
+			}
+			deprovisionController.HandleBlockingPods(context.TODO(), []corev1.Pod{*pod}, setupBlockedNode("test-node", "default", tt.overdue))
+
+			updatedPod := &corev1.Pod{}
+			assert.NoError(t, deprovisionController.Client.Get(context.TODO(), client.ObjectKeyFromObject(pod), updatedPod))
+			close(recorder.Events)
+			if tt.expectRemoved {
+				assert.NotContains(t, updatedPod.Annotations, karpv1.DoNotDisruptAnnotationKey, "Expected annotation to be removed")
+				// Followed by the summary on the node
+				assert.Len(t, recorder.Events, 2)
+				assert.True(t, strings.Contains(<-recorder.Events, controller.MaxBlockDurationExceededEventReason))
+			} else {
+				assert.Equal(t, "true", updatedPod.Annotations[karpv1.DoNotDisruptAnnotationKey], "Expected annotation to be unchanged")
+				assert.Empty(t, recorder.Events)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/metrics"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrlruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+// ConditionTypeExpired is the status condition older Karpenter releases set on expired NodeClaims.
+// Karpenter v1 no longer sets it, so expiration is also derived from spec.expireAfter.
+const ConditionTypeExpired = "Expired"
+
+// NodeClaimController watches NodeClaims directly and hands blocking pods on disrupted nodes
+// to the same path used for DisruptionBlocked events.
+type NodeClaimController struct {
+	*DeprovisionController
+}
+
+func (c *NodeClaimController) Reconcile(ctx context.Context, nc *karpv1.NodeClaim) (reconcile.Result, error) {
+	defer metrics.ObserveReconcile("nodeclaim", time.Now())
+	// NodeClaims that haven't registered a Node yet can't have blocking pods
+	if nc.Status.NodeName == "" {
+		return reconcile.Result{}, nil
+	}
+
+	var podList corev1.PodList
+	if err := c.Client.List(ctx, &podList, client.MatchingFields{"spec.nodeName": nc.Status.NodeName}); err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed getting pods from cache: %w", err)
+	}
+
+	now := time.Now()
+	reason, ok := DisruptionReasonFor(nc, podList.Items, now)
+	if !ok {
+		// Nothing will trigger a watch event when expireAfter elapses so check back at that point
+		if expiresAt, hasExpiry := ExpirationTime(nc); hasExpiry {
+			return reconcile.Result{RequeueAfter: expiresAt.Sub(now)}, nil
+		}
+		return reconcile.Result{}, nil
+	}
+
+	return c.HandleBlockingPods(ctx, podList.Items, BlockedNode{Name: nc.Status.NodeName, Reason: reason, NodeClaim: nc}), nil
+}
+
+func (c *NodeClaimController) Register(_ context.Context, mgr manager.Manager) error {
+	return ctrlruntime.NewControllerManagedBy(mgr).
+		Named("deprovision-nodeclaim").
+		For(&karpv1.NodeClaim{}, builder.WithPredicates(predicate.Funcs{
+			// Deleted NodeClaims no longer have a node to unblock
+			DeleteFunc: func(e event.DeleteEvent) bool { return false },
+		})).
+		Complete(reconcile.AsReconciler(mgr.GetClient(), c))
+}
+
+// ExpirationTime returns when the NodeClaim expires based on its creation timestamp and spec.expireAfter.
+// The second return value is false when expireAfter is set to Never.
+func ExpirationTime(nc *karpv1.NodeClaim) (time.Time, bool) {
+	if nc.Spec.ExpireAfter.Duration == nil {
+		return time.Time{}, false
+	}
+	return nc.CreationTimestamp.Add(*nc.Spec.ExpireAfter.Duration), true
+}
+
+// IsNodeClaimExpired checks whether the NodeClaim has outlived its expireAfter or carries an Expired condition.
+func IsNodeClaimExpired(nc *karpv1.NodeClaim, now time.Time) bool {
+	if nc.StatusConditions().Get(ConditionTypeExpired).IsTrue() {
+		return true
+	}
+	expiresAt, ok := ExpirationTime(nc)
+	return ok && !expiresAt.After(now)
+}
@@ -0,0 +1,230 @@
+package controller_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/clienthelpers"
+	"github.com/jukie/karpenter-deprovision-controller/pkg/controller"
+	"github.com/jukie/karpenter-deprovision-controller/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+func setupTestNodeClaim(nodeName string, age time.Duration, expireAfter string, conditions ...string) *karpv1.NodeClaim {
+	nc := &karpv1.NodeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "test-nodeclaim",
+			CreationTimestamp: metav1.Time{Time: time.Now().Add(-age)},
+		},
+		Spec: karpv1.NodeClaimSpec{
+			ExpireAfter: karpv1.MustParseNillableDuration(expireAfter),
+		},
+		Status: karpv1.NodeClaimStatus{NodeName: nodeName},
+	}
+	for _, condition := range conditions {
+		nc.StatusConditions().SetTrue(condition)
+	}
+	return nc
+}
+
+func TestNodeClaimController_Reconcile(t *testing.T) {
+	tests := []struct {
+		name                    string
+		nodeClaim               *karpv1.NodeClaim
+		reasonPolicies          map[controller.DisruptionReason]controller.ReasonPolicy
+		expectAnnotationRemoved bool
+		expectRequeue           bool
+	}{
+		{
+			name:                    "Not yet expired",
+			nodeClaim:               setupTestNodeClaim("test-node", time.Hour, "2h"),
+			expectAnnotationRemoved: false,
+			expectRequeue:           true,
+		},
+		{
+			name:                    "Never expires",
+			nodeClaim:               setupTestNodeClaim("test-node", time.Hour, "Never"),
+			expectAnnotationRemoved: false,
+			expectRequeue:           false,
+		},
+		{
+			name:                    "Expired from expireAfter",
+			nodeClaim:               setupTestNodeClaim("test-node", 3*time.Hour, "2h"),
+			expectAnnotationRemoved: true,
+		},
+		{
+			name:                    "Expired condition",
+			nodeClaim:               setupTestNodeClaim("test-node", time.Hour, "Never", controller.ConditionTypeExpired),
+			expectAnnotationRemoved: true,
+		},
+		{
+			name:                    "Drifted condition",
+			nodeClaim:               setupTestNodeClaim("test-node", time.Hour, "2h", karpv1.ConditionTypeDrifted),
+			expectAnnotationRemoved: true,
+		},
+		{
+			name:                    "Drifted but drift unblocking disabled",
+			nodeClaim:               setupTestNodeClaim("test-node", time.Hour, "2h", karpv1.ConditionTypeDrifted),
+			reasonPolicies:          map[controller.DisruptionReason]controller.ReasonPolicy{controller.ReasonExpired: {AllowUnblock: true}},
+			expectAnnotationRemoved: false,
+		},
+		{
+			name:                    "Underutilized is opt-in by default",
+			nodeClaim:               setupTestNodeClaim("test-node", time.Hour, "2h", karpv1.ConditionTypeConsolidatable),
+			expectAnnotationRemoved: false,
+		},
+		{
+			name:                    "Underutilized when opted in",
+			nodeClaim:               setupTestNodeClaim("test-node", time.Hour, "2h", karpv1.ConditionTypeConsolidatable),
+			reasonPolicies:          map[controller.DisruptionReason]controller.ReasonPolicy{controller.ReasonUnderutilized: {AllowUnblock: true}},
+			expectAnnotationRemoved: true,
+		},
+		{
+			name:                    "Node not registered",
+			nodeClaim:               setupTestNodeClaim("", 3*time.Hour, "2h"),
+			expectAnnotationRemoved: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := setupTestPod("blocking-no-sched", "testing", "test-node", map[string]string{
+				karpv1.DoNotDisruptAnnotationKey: "true",
+			})
+			ncController := &controller.NodeClaimController{
+				DeprovisionController: &controller.DeprovisionController{
+					Client: fake.NewClientBuilder().
+						WithObjects(pod, tt.nodeClaim).
+						WithIndex(&corev1.Pod{}, "spec.nodeName", clienthelpers.PodIdxFunc).
+						WithIndex(&karpv1.NodeClaim{}, "status.nodeName", clienthelpers.NodeClaimIdxFunc).
+						Build(),
+					ReasonPolicies: tt.reasonPolicies,
+				},
+			}
+
+			result, err := ncController.Reconcile(context.TODO(), tt.nodeClaim)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectRequeue, result.RequeueAfter > 0)
+
+			updatedPod := &corev1.Pod{}
+			err = ncController.Client.Get(context.TODO(), types.NamespacedName{Name: pod.Name, Namespace: pod.Namespace}, updatedPod)
+			assert.NoError(t, err)
+
+			if tt.expectAnnotationRemoved {
+				assert.Equal(t, "", updatedPod.Annotations[karpv1.DoNotDisruptAnnotationKey], "Expected annotation to be removed")
+			} else {
+				assert.Equal(t, "true", updatedPod.Annotations[karpv1.DoNotDisruptAnnotationKey], "Expected annotation to be unchanged")
+			}
+		})
+	}
+}
+
+func TestNodeClaimController_AlreadyUnblockedByEvent(t *testing.T) {
+	nodeClaim := setupTestNodeClaim("test-node", 3*time.Hour, "2h")
+	pod := setupTestPod("blocking-no-sched", "duplicate-reconcile", "test-node", map[string]string{
+		karpv1.DoNotDisruptAnnotationKey: "true",
+	})
+	// The event reconciler read the pod from the cache before the NodeClaim reconciler's patch showed up in it
+	stalePod := *pod.DeepCopy()
+	ncController := &controller.NodeClaimController{
+		DeprovisionController: &controller.DeprovisionController{
+			Client: fake.NewClientBuilder().
+				WithObjects(pod, nodeClaim).
+				WithIndex(&corev1.Pod{}, "spec.nodeName", clienthelpers.PodIdxFunc).
+				WithIndex(&karpv1.NodeClaim{}, "status.nodeName", clienthelpers.NodeClaimIdxFunc).
+				Build(),
+		},
+	}
+
+	_, err := ncController.Reconcile(context.TODO(), nodeClaim)
+	assert.NoError(t, err)
+	ncController.HandleBlockingPods(context.TODO(), []corev1.Pod{stalePod}, controller.BlockedNode{Name: "test-node", Reason: controller.ReasonExpired, NodeClaim: nodeClaim})
+
+	patches := func(outcome string) float64 {
+		return testutil.ToFloat64(metrics.PatchCounter.With(prometheus.Labels{
+			metrics.KindLabel:      "Pod",
+			metrics.NamespaceLabel: pod.Namespace,
+			metrics.OutcomeLabel:   outcome,
+		}))
+	}
+	assert.Equal(t, float64(1), patches(metrics.OutcomeSuccess))
+	assert.Equal(t, float64(0), patches(metrics.OutcomeFailure), "Expected the second remove to be a no-op")
+}
+
+func TestParseReasonPolicies(t *testing.T) {
+	policies, err := controller.ParseReasonPolicies("Expired, Underutilized")
+	assert.NoError(t, err)
+	assert.True(t, policies[controller.ReasonExpired].AllowUnblock)
+	assert.True(t, policies[controller.ReasonUnderutilized].AllowUnblock)
+	assert.False(t, policies[controller.ReasonDrifted].AllowUnblock)
+	assert.False(t, policies[controller.ReasonEmpty].AllowUnblock)
+
+	_, err = controller.ParseReasonPolicies("Expired,Bogus")
+	assert.Error(t, err)
+}
+
+func TestDisruptionReasonFor(t *testing.T) {
+	daemonSetPod := *setupTestPod("daemon", "testing", "test-node", nil)
+	daemonSetPod.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "daemon", Controller: ptr.To(true)}}
+	workloadPod := *setupTestPod("workload", "testing", "test-node", nil)
+	deletingNodeClaim := setupTestNodeClaim("test-node", time.Hour, "2h", karpv1.ConditionTypeConsolidatable)
+	deletingNodeClaim.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+
+	tests := []struct {
+		name      string
+		nodeClaim *karpv1.NodeClaim
+		pods      []corev1.Pod
+		want      controller.DisruptionReason
+		wantOk    bool
+	}{
+		{
+			name:      "Expiration takes precedence over drift",
+			nodeClaim: setupTestNodeClaim("test-node", 3*time.Hour, "2h", karpv1.ConditionTypeDrifted),
+			want:      controller.ReasonExpired,
+			wantOk:    true,
+		},
+		{
+			name:      "Deleting without expiring or drifting",
+			nodeClaim: deletingNodeClaim,
+			want:      controller.ReasonDeleting,
+			wantOk:    true,
+		},
+		{
+			name:      "Consolidatable with workload pods",
+			nodeClaim: setupTestNodeClaim("test-node", time.Hour, "2h", karpv1.ConditionTypeConsolidatable),
+			pods:      []corev1.Pod{daemonSetPod, workloadPod},
+			want:      controller.ReasonUnderutilized,
+			wantOk:    true,
+		},
+		{
+			name:      "Consolidatable with only DaemonSet pods",
+			nodeClaim: setupTestNodeClaim("test-node", time.Hour, "2h", karpv1.ConditionTypeConsolidatable),
+			pods:      []corev1.Pod{daemonSetPod},
+			want:      controller.ReasonEmpty,
+			wantOk:    true,
+		},
+		{
+			name:      "Healthy",
+			nodeClaim: setupTestNodeClaim("test-node", time.Hour, "2h"),
+			wantOk:    false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := controller.DisruptionReasonFor(tt.nodeClaim, tt.pods, time.Now())
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	pdbBackoffBase = 30 * time.Second
+	pdbBackoffMax  = 10 * time.Minute
+)
+
+// newPDBBackoff returns the per-node backoff used when PodDisruptionBudgets prevent unblocking.
+func newPDBBackoff() workqueue.TypedRateLimiter[string] {
+	return workqueue.NewTypedItemExponentialFailureRateLimiter[string](pdbBackoffBase, pdbBackoffMax)
+}
+
+// pdbBudget tracks how many more pods each PodDisruptionBudget lets the controller unblock.
+// Pods that were already unblocked but are still running count against the budget, since
+// Karpenter may evict all of them at once.
+type pdbBudget struct {
+	client    client.Client
+	markerKey AnnotationKey
+	pdbs      map[string][]policyv1.PodDisruptionBudget
+	remaining map[types.NamespacedName]int32
+}
+
+func newPDBBudget(c client.Client, markerKey AnnotationKey) *pdbBudget {
+	return &pdbBudget{
+		client:    c,
+		markerKey: markerKey,
+		pdbs:      map[string][]policyv1.PodDisruptionBudget{},
+		remaining: map[types.NamespacedName]int32{},
+	}
+}
+
+// reserve checks every PodDisruptionBudget matching the pod and, if all of them still allow a disruption,
+// claims one from each. The name of the first exhausted PDB is returned when the pod can't be unblocked.
+func (b *pdbBudget) reserve(ctx context.Context, pod *corev1.Pod) (string, bool, error) {
+	matching, err := b.matchingPDBs(ctx, pod)
+	if err != nil {
+		return "", false, err
+	}
+	for _, pdb := range matching {
+		remaining, err := b.remainingFor(ctx, pdb)
+		if err != nil {
+			return "", false, err
+		}
+		if remaining <= 0 {
+			return pdb.Name, false, nil
+		}
+	}
+	for _, pdb := range matching {
+		b.remaining[client.ObjectKeyFromObject(pdb)]--
+	}
+	return "", true, nil
+}
+
+func (b *pdbBudget) matchingPDBs(ctx context.Context, pod *corev1.Pod) ([]*policyv1.PodDisruptionBudget, error) {
+	pdbs, ok := b.pdbs[pod.Namespace]
+	if !ok {
+		var pdbList policyv1.PodDisruptionBudgetList
+		if err := b.client.List(ctx, &pdbList, client.InNamespace(pod.Namespace)); err != nil {
+			return nil, fmt.Errorf("failed listing pod disruption budgets in namespace %s: %w", pod.Namespace, err)
+		}
+		pdbs = pdbList.Items
+		b.pdbs[pod.Namespace] = pdbs
+	}
+
+	var matching []*policyv1.PodDisruptionBudget
+	for i := range pdbs {
+		selector, err := metav1.LabelSelectorAsSelector(pdbs[i].Spec.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector on pod disruption budget %s/%s: %w", pdbs[i].Namespace, pdbs[i].Name, err)
+		}
+		if selector.Matches(labels.Set(pod.Labels)) {
+			matching = append(matching, &pdbs[i])
+		}
+	}
+	return matching, nil
+}
+
+// remainingFor returns the disruptions left on the PDB after subtracting pods that are already unblocked.
+func (b *pdbBudget) remainingFor(ctx context.Context, pdb *policyv1.PodDisruptionBudget) (int32, error) {
+	key := client.ObjectKeyFromObject(pdb)
+	if remaining, ok := b.remaining[key]; ok {
+		return remaining, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+	if err != nil {
+		return 0, fmt.Errorf("invalid selector on pod disruption budget %s/%s: %w", pdb.Namespace, pdb.Name, err)
+	}
+	var podList corev1.PodList
+	if err := b.client.List(ctx, &podList, client.InNamespace(pdb.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return 0, fmt.Errorf("failed getting pods for pod disruption budget %s/%s: %w", pdb.Namespace, pdb.Name, err)
+	}
+	remaining := pdb.Status.DisruptionsAllowed
+	for _, pod := range podList.Items {
+		if unblocked := b.markerKey.Has(pod.Annotations); unblocked && pod.DeletionTimestamp == nil && pod.Status.Phase == corev1.PodRunning {
+			remaining--
+		}
+	}
+	b.remaining[key] = remaining
+	return remaining, nil
+}
@@ -0,0 +1,114 @@
+package controller_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/controller"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+func setupTestPDB(name string, disruptionsAllowed int32) *policyv1.PodDisruptionBudget {
+	return &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "testing"},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "api"}},
+		},
+		Status: policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: disruptionsAllowed},
+	}
+}
+
+func TestHandleBlockingPods_PodDisruptionBudgets(t *testing.T) {
+	blockingPod := func(name, nodeName string) *corev1.Pod {
+		pod := setupTestPod(name, "testing", nodeName, map[string]string{karpv1.DoNotDisruptAnnotationKey: "true"})
+		pod.Labels = map[string]string{"app": "api"}
+		return pod
+	}
+	alreadyUnblocked := setupTestPod("already-unblocked", "testing", "other-node", map[string]string{controller.OriginalDoNotDisruptKey: "true"})
+	alreadyUnblocked.Labels = map[string]string{"app": "api"}
+	alreadyUnblocked.Status.Phase = corev1.PodRunning
+
+	tests := []struct {
+		name          string
+		objList       []runtime.Object
+		expectRemoved int
+		expectRequeue bool
+	}{
+		{
+			name:          "No pod disruption budget",
+			expectRemoved: 3,
+		},
+		{
+			name:          "Budget allows one disruption",
+			objList:       []runtime.Object{setupTestPDB("api", 1)},
+			expectRemoved: 1,
+			expectRequeue: true,
+		},
+		{
+			name:          "Budget consumed by pod unblocked on another node",
+			objList:       []runtime.Object{setupTestPDB("api", 1), alreadyUnblocked},
+			expectRemoved: 0,
+			expectRequeue: true,
+		},
+		{
+			name:          "Most restrictive budget wins",
+			objList:       []runtime.Object{setupTestPDB("api", 3), setupTestPDB("api-strict", 2)},
+			expectRemoved: 2,
+			expectRequeue: true,
+		},
+		{
+			name:          "Budget allows all disruptions",
+			objList:       []runtime.Object{setupTestPDB("api", 5)},
+			expectRemoved: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pods := []*corev1.Pod{blockingPod("api-1", "test-node"), blockingPod("api-2", "test-node"), blockingPod("api-3", "test-node")}
+			objList := tt.objList
+			var podItems []corev1.Pod
+			for _, pod := range pods {
+				objList = append(objList, pod)
+				podItems = append(podItems, *pod)
+			}
+			deprovisionController := &controller.DeprovisionController{
+				Client: fake.NewClientBuilder().WithRuntimeObjects(objList...).Build(),
+			}
+
+			result := deprovisionController.HandleBlockingPods(context.TODO(), podItems, controller.BlockedNode{Name: "test-node", Reason: controller.ReasonExpired})
+			assert.Equal(t, tt.expectRequeue, result.RequeueAfter > 0)
+
+			removed := 0
+			for _, pod := range pods {
+				updatedPod := &corev1.Pod{}
+				assert.NoError(t, deprovisionController.Client.Get(context.TODO(), types.NamespacedName{Name: pod.Name, Namespace: pod.Namespace}, updatedPod))
+				if _, ok := updatedPod.Annotations[karpv1.DoNotDisruptAnnotationKey]; !ok {
+					removed++
+				}
+			}
+			assert.Equal(t, tt.expectRemoved, removed)
+		})
+	}
+}
+
+func TestHandleBlockingPods_PodDisruptionBudgetBackoff(t *testing.T) {
+	pod := setupTestPod("api-1", "testing", "test-node", map[string]string{karpv1.DoNotDisruptAnnotationKey: "true"})
+	pod.Labels = map[string]string{"app": "api"}
+	deprovisionController := &controller.DeprovisionController{
+		Client: fake.NewClientBuilder().WithRuntimeObjects(pod, setupTestPDB("api", 0)).Build(),
+	}
+	node := controller.BlockedNode{Name: "test-node", Reason: controller.ReasonExpired}
+
+	first := deprovisionController.HandleBlockingPods(context.TODO(), []corev1.Pod{*pod}, node)
+	second := deprovisionController.HandleBlockingPods(context.TODO(), []corev1.Pod{*pod}, node)
+	assert.Greater(t, second.RequeueAfter, first.RequeueAfter, "Expected requeue delay to back off")
+}
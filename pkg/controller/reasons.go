@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+// DisruptionReason describes why Karpenter wants to disrupt a node.
+type DisruptionReason string
+
+const (
+	ReasonExpired       DisruptionReason = "Expired"
+	ReasonDrifted       DisruptionReason = DisruptionReason(karpv1.DisruptionReasonDrifted)
+	ReasonUnderutilized DisruptionReason = DisruptionReason(karpv1.DisruptionReasonUnderutilized)
+	ReasonEmpty         DisruptionReason = DisruptionReason(karpv1.DisruptionReasonEmpty)
+	// ReasonDeleting is a NodeClaim that Karpenter is already deleting without it having expired or drifted
+	ReasonDeleting DisruptionReason = "Deleting"
+	// ReasonBlocked is a node named by a DisruptionBlocked event whose NodeClaim gives no reason for disrupting it
+	ReasonBlocked DisruptionReason = "Blocked"
+)
+
+// KnownDisruptionReasons lists every reason the controller can detect, in order of precedence.
+var KnownDisruptionReasons = []DisruptionReason{ReasonExpired, ReasonDrifted, ReasonDeleting, ReasonUnderutilized, ReasonEmpty, ReasonBlocked}
+
+// ReasonPolicy controls how blocking pods are handled for a single DisruptionReason.
+type ReasonPolicy struct {
+	// AllowUnblock permits removing do-not-disrupt annotations from pods on nodes disrupted for this reason.
+	AllowUnblock bool
+}
+
+// DefaultReasonPolicies unblocks expired, drifted and deleting nodes while leaving consolidation and unexplained
+// blocks opt-in.
+var DefaultReasonPolicies = map[DisruptionReason]ReasonPolicy{
+	ReasonExpired:       {AllowUnblock: true},
+	ReasonDrifted:       {AllowUnblock: true},
+	ReasonDeleting:      {AllowUnblock: true},
+	ReasonUnderutilized: {AllowUnblock: false},
+	ReasonEmpty:         {AllowUnblock: false},
+	ReasonBlocked:       {AllowUnblock: false},
+}
+
+// ParseReasonPolicies builds reason policies from a comma-separated list of reasons that may be unblocked.
+// Reasons missing from the list are still detected but their blocking pods are left alone.
+func ParseReasonPolicies(reasons string) (map[DisruptionReason]ReasonPolicy, error) {
+	policies := map[DisruptionReason]ReasonPolicy{}
+	for _, reason := range KnownDisruptionReasons {
+		policies[reason] = ReasonPolicy{AllowUnblock: false}
+	}
+	for _, r := range strings.Split(reasons, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		if _, ok := policies[DisruptionReason(r)]; !ok {
+			return nil, fmt.Errorf("unknown disruption reason %q, must be one of %v", r, KnownDisruptionReasons)
+		}
+		policies[DisruptionReason(r)] = ReasonPolicy{AllowUnblock: true}
+	}
+	return policies, nil
+}
+
+// BlockedNode describes a node whose disruption is blocked by pods carrying the do-not-disrupt annotation.
+type BlockedNode struct {
+	Name   string
+	Reason DisruptionReason
+	// NodeClaim is nil when the node could not be matched to a NodeClaim
+	NodeClaim *karpv1.NodeClaim
+}
+
+// NodePool returns the name of the NodePool that owns the node, empty when unknown.
+func (n BlockedNode) NodePool() string {
+	if n.NodeClaim == nil {
+		return ""
+	}
+	return n.NodeClaim.Labels[karpv1.NodePoolLabelKey]
+}
+
+// DisruptedSince returns when the node first became eligible for disruption for its reason.
+// Nodes without a NodeClaim fall back to now since there's nothing to go on.
+func (n BlockedNode) DisruptedSince(now time.Time) time.Time {
+	if n.NodeClaim == nil {
+		return now
+	}
+	conditionType := ""
+	switch n.Reason {
+	case ReasonExpired:
+		if expiresAt, ok := ExpirationTime(n.NodeClaim); ok && !expiresAt.After(now) {
+			return expiresAt
+		}
+		conditionType = ConditionTypeExpired
+	case ReasonDrifted:
+		conditionType = karpv1.ConditionTypeDrifted
+	case ReasonDeleting:
+		if n.NodeClaim.DeletionTimestamp != nil {
+			return n.NodeClaim.DeletionTimestamp.Time
+		}
+	case ReasonUnderutilized, ReasonEmpty:
+		conditionType = karpv1.ConditionTypeConsolidatable
+	}
+	if condition := n.NodeClaim.StatusConditions().Get(conditionType); condition.IsTrue() {
+		return condition.LastTransitionTime.Time
+	}
+	return now
+}
+
+// description is used when logging why blocking pods are being handled.
+func (r DisruptionReason) description() string {
+	switch r {
+	case ReasonDrifted:
+		return "has drifted from its NodePool or NodeClass"
+	case ReasonDeleting:
+		return "is already being deleted"
+	case ReasonUnderutilized:
+		return "is underutilized and can be consolidated"
+	case ReasonEmpty:
+		return "is empty and can be consolidated"
+	case ReasonBlocked:
+		return "is blocked from being disrupted although its NodeClaim gives no reason for disrupting it"
+	default:
+		return "has exceeded its max lifetime"
+	}
+}
+
+// DisruptionReasonFor determines why the NodeClaim is being disrupted from its expiration, status conditions and
+// deletion timestamp. The second return value is false when Karpenter has no reason to disrupt the NodeClaim.
+func DisruptionReasonFor(nc *karpv1.NodeClaim, pods []corev1.Pod, now time.Time) (DisruptionReason, bool) {
+	switch {
+	case IsNodeClaimExpired(nc, now):
+		return ReasonExpired, true
+	case nc.StatusConditions().Get(karpv1.ConditionTypeDrifted).IsTrue():
+		return ReasonDrifted, true
+	case nc.DeletionTimestamp != nil:
+		return ReasonDeleting, true
+	case nc.StatusConditions().Get(karpv1.ConditionTypeConsolidatable).IsTrue():
+		for _, pod := range pods {
+			if !isDaemonSetPod(pod) {
+				return ReasonUnderutilized, true
+			}
+		}
+		return ReasonEmpty, true
+	}
+	return "", false
+}
+
+func isDaemonSetPod(pod corev1.Pod) bool {
+	owner := metav1.GetControllerOf(&pod)
+	return owner != nil && owner.Kind == "DaemonSet"
+}
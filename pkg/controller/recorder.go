@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	DoNotDisruptRemovedEventReason      = "DoNotDisruptRemoved"
+	DisruptionWindowInactiveEventReason = "DisruptionWindowInactive"
+	InvalidDisruptionWindowEventReason  = "InvalidDisruptionWindow"
+	BlockingPodsUnblockedEventReason    = "BlockingPodsUnblocked"
+)
+
+// EventVerbosity decides which decisions are recorded as Kubernetes Events.
+type EventVerbosity string
+
+const (
+	// EventVerbosityOff records no Events
+	EventVerbosityOff EventVerbosity = "Off"
+	// EventVerbosityNormal records removed annotations, invalid disruption windows, cordons and evictions
+	EventVerbosityNormal EventVerbosity = "Normal"
+	// EventVerbosityVerbose additionally records pods left blocking because their disruption window is inactive
+	EventVerbosityVerbose EventVerbosity = "Verbose"
+)
+
+// EventSettings configures the Kubernetes Events recorded on pods and nodes.
+type EventSettings struct {
+	Verbosity EventVerbosity `json:"verbosity"`
+}
+
+// DefaultEventSettings record every action taken without the decisions to leave pods alone.
+var DefaultEventSettings = EventSettings{Verbosity: EventVerbosityNormal}
+
+// ParseEventVerbosity parses Off, Normal or Verbose.
+func ParseEventVerbosity(value string) (EventVerbosity, error) {
+	switch verbosity := EventVerbosity(value); verbosity {
+	case EventVerbosityOff, EventVerbosityNormal, EventVerbosityVerbose:
+		return verbosity, nil
+	}
+	return "", fmt.Errorf("unknown event verbosity %q, expected %s, %s or %s", value, EventVerbosityOff, EventVerbosityNormal, EventVerbosityVerbose)
+}
+
+// enabled reports whether Events of the given verbosity are recorded.
+func (v EventVerbosity) enabled(level EventVerbosity) bool {
+	switch v {
+	case EventVerbosityVerbose:
+		return true
+	case EventVerbosityNormal:
+		return level == EventVerbosityNormal
+	}
+	return false
+}
+
+// recordEvent records an Event at EventVerbosityNormal.
+func (c *DeprovisionController) recordEvent(obj runtime.Object, eventType, reason, message string) {
+	c.recordEventAt(EventVerbosityNormal, obj, eventType, reason, message)
+}
+
+// recordVerboseEvent records an Event at EventVerbosityVerbose.
+func (c *DeprovisionController) recordVerboseEvent(obj runtime.Object, eventType, reason, message string) {
+	c.recordEventAt(EventVerbosityVerbose, obj, eventType, reason, message)
+}
+
+// recordEventAt records the Event if the configured verbosity includes level. Nothing is recorded in dry-run mode since
+// the annotations, cordons and evictions the Events report weren't actually applied.
+func (c *DeprovisionController) recordEventAt(level EventVerbosity, obj runtime.Object, eventType, reason, message string) {
+	if c.Recorder == nil || c.DryRun || !c.currentConfig().Events.Verbosity.enabled(level) {
+		return
+	}
+	c.Recorder.Event(obj, eventType, reason, message)
+}
+
+// nodeReference returns the Node to record Events on, falling back to one with just a name when it isn't cached so
+// the Event is still recorded.
+func (c *DeprovisionController) nodeReference(ctx context.Context, name string) *corev1.Node {
+	node := &corev1.Node{}
+	if err := c.Client.Get(ctx, types.NamespacedName{Name: name}, node); err != nil {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	}
+	return node
+}
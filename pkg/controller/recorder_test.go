@@ -0,0 +1,129 @@
+package controller_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/controller"
+	"github.com/jukie/karpenter-deprovision-controller/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+func TestHandleBlockingPods_Events(t *testing.T) {
+	activeSchedule := fmt.Sprintf("%d %d * * *", time.Now().UTC().Minute(), time.Now().UTC().Hour())
+	inactiveSchedule := fmt.Sprintf("%d %d * * *", time.Now().UTC().Minute(), time.Now().Add(-4*time.Hour).UTC().Hour())
+
+	tests := []struct {
+		name      string
+		verbosity controller.EventVerbosity
+		dryRun    bool
+		expected  []string
+	}{
+		{
+			name:      "Off",
+			verbosity: controller.EventVerbosityOff,
+		},
+		{
+			name:      "Normal",
+			verbosity: controller.EventVerbosityNormal,
+			expected: []string{
+				"Warning InvalidDisruptionWindow Disruption window schedule \"not a schedule\" is invalid",
+				"Normal DoNotDisruptRemoved Removed karpenter.sh/do-not-disrupt so node test-node, which has exceeded its max lifetime, can be disrupted",
+				"Normal DoNotDisruptRemoved Removed karpenter.sh/do-not-disrupt so node test-node, which has exceeded its max lifetime, can be disrupted",
+				"Normal BlockingPodsUnblocked Removed karpenter.sh/do-not-disrupt from 2 of 3 blocking pods",
+			},
+		},
+		{
+			name:      "Verbose",
+			verbosity: controller.EventVerbosityVerbose,
+			expected: []string{
+				"Warning InvalidDisruptionWindow Disruption window schedule \"not a schedule\" is invalid",
+				"Normal DisruptionWindowInactive Node test-node has exceeded its max lifetime, leaving karpenter.sh/do-not-disrupt until the disruption window opens at",
+				"Normal DoNotDisruptRemoved Removed karpenter.sh/do-not-disrupt so node test-node, which has exceeded its max lifetime, can be disrupted",
+				"Normal DoNotDisruptRemoved Removed karpenter.sh/do-not-disrupt so node test-node, which has exceeded its max lifetime, can be disrupted",
+				"Normal BlockingPodsUnblocked Removed karpenter.sh/do-not-disrupt from 2 of 3 blocking pods",
+			},
+		},
+		{
+			name:      "Dry run",
+			verbosity: controller.EventVerbosityVerbose,
+			dryRun:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pods := []corev1.Pod{
+				*setupTestPod("invalid-schedule", "testing", "test-node", map[string]string{
+					karpv1.DoNotDisruptAnnotationKey:    "true",
+					controller.DisruptionWindowSchedKey: "not a schedule",
+				}),
+				*setupTestPod("inactive-window", "testing", "test-node", map[string]string{
+					karpv1.DoNotDisruptAnnotationKey:    "true",
+					controller.DisruptionWindowSchedKey: inactiveSchedule,
+				}),
+				*setupTestPod("active-window", "testing", "test-node", map[string]string{
+					karpv1.DoNotDisruptAnnotationKey:    "true",
+					controller.DisruptionWindowSchedKey: activeSchedule,
+				}),
+				*setupTestPod("not-blocking", "testing", "test-node", nil),
+			}
+			fakeClient := fake.NewClientBuilder().WithRuntimeObjects(setupTestNode("test-node", "default"))
+			for i := range pods {
+				fakeClient.WithRuntimeObjects(&pods[i])
+			}
+			config := controller.DefaultConfig()
+			config.Events.Verbosity = tt.verbosity
+			recorder := record.NewFakeRecorder(10)
+			deprovisionController := &controller.DeprovisionController{Client: fakeClient.Build(), Recorder: recorder, DryRun: tt.dryRun}
+			deprovisionController.ApplyConfig(config)
+			unblockedPods := metrics.UnblockedPodsCounter.With(prometheus.Labels{
+				metrics.NamespaceLabel: "testing",
+				metrics.TriggerLabel:   metrics.TriggerWindow,
+			})
+			patches := metrics.PatchCounter.With(prometheus.Labels{
+				metrics.KindLabel:      "Pod",
+				metrics.NamespaceLabel: "testing",
+				metrics.OutcomeLabel:   metrics.OutcomeSuccess,
+			})
+			unblockedBefore, patchesBefore := testutil.ToFloat64(unblockedPods), testutil.ToFloat64(patches)
+			deprovisionController.HandleBlockingPods(context.TODO(), pods, controller.BlockedNode{Name: "test-node", Reason: controller.ReasonExpired})
+			if tt.dryRun {
+				assert.Equal(t, unblockedBefore, testutil.ToFloat64(unblockedPods), "Dry runs shouldn't count unblocked pods")
+				assert.Equal(t, patchesBefore, testutil.ToFloat64(patches), "Dry runs shouldn't count patches")
+			}
+
+			close(recorder.Events)
+			var events []string
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			assert.Len(t, events, len(tt.expected))
+			for i := range tt.expected {
+				if i < len(events) {
+					assert.True(t, strings.HasPrefix(events[i], tt.expected[i]), "Expected event %q to start with %q", events[i], tt.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseEventVerbosity(t *testing.T) {
+	verbosity, err := controller.ParseEventVerbosity("Verbose")
+	assert.NoError(t, err)
+	assert.Equal(t, controller.EventVerbosityVerbose, verbosity)
+
+	_, err = controller.ParseEventVerbosity("verbose")
+	assert.Error(t, err)
+}
@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/apis/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// ResolvedWindow is the disruption window that applies to a pod after merging DisruptionPolicies with pod annotations.
+type ResolvedWindow struct {
+	Schedule string
+	Duration string
+	Timezone string
+	// Enabled is false when the winning policy forbids removing do-not-disrupt annotations.
+	Enabled bool
+	// Policy is the name of the DisruptionPolicy that won, empty when none selected the pod.
+	Policy string
+}
+
+// WindowResolver merges DisruptionPolicy resources with per-pod annotations to find a pod's disruption window.
+// A nil WindowResolver only considers pod annotations.
+type WindowResolver struct {
+	Client client.Client
+}
+
+// Resolve returns the effective disruption window for the pod. Annotation values override the winning policy field by
+// field, except that a schedule annotation always comes with the timezone annotation, or UTC without one, so the same
+// annotations mean the same window whichever policy matches.
+func (r *WindowResolver) Resolve(ctx context.Context, pod *corev1.Pod) (ResolvedWindow, error) {
+	return r.ResolveWithKeys(ctx, pod, DefaultAnnotationKeys)
+}
+
+// ResolveWithKeys is Resolve reading the pod's disruption window from the given annotation keys.
+func (r *WindowResolver) ResolveWithKeys(ctx context.Context, pod *corev1.Pod, keys AnnotationKeys) (ResolvedWindow, error) {
+	window := ResolvedWindow{Enabled: true}
+	if r != nil {
+		policy, err := r.matchingPolicy(ctx, pod)
+		if err != nil {
+			return window, err
+		}
+		if policy != nil {
+			window.Policy = policy.Name
+			window.Schedule = policy.Spec.Schedule
+			window.Timezone = policy.Spec.Timezone
+			if policy.Spec.Duration != nil {
+				window.Duration = policy.Spec.Duration.Duration.String()
+			}
+			if policy.Spec.Enabled != nil {
+				window.Enabled = *policy.Spec.Enabled
+			}
+		}
+	}
+
+	if sched, _ := readAnnotation(pod, keys.Schedule); sched != "" {
+		window.Schedule = sched
+		window.Timezone = ""
+	}
+	if duration, _ := readAnnotation(pod, keys.Duration); duration != "" {
+		window.Duration = duration
+	}
+	if tz, _ := readAnnotation(pod, keys.Timezone); tz != "" {
+		window.Timezone = tz
+	}
+	return window, nil
+}
+
+// matchingPolicy returns the highest weighted DisruptionPolicy selecting the pod, or nil if none do.
+func (r *WindowResolver) matchingPolicy(ctx context.Context, pod *corev1.Pod) (*v1alpha1.DisruptionPolicy, error) {
+	var policyList v1alpha1.DisruptionPolicyList
+	if err := r.Client.List(ctx, &policyList); err != nil {
+		return nil, fmt.Errorf("failed listing disruption policies: %w", err)
+	}
+	if len(policyList.Items) == 0 {
+		return nil, nil
+	}
+
+	policies := policyList.Items
+	sort.Slice(policies, func(i, j int) bool {
+		if policies[i].Spec.Weight != policies[j].Spec.Weight {
+			return policies[i].Spec.Weight > policies[j].Spec.Weight
+		}
+		return policies[i].Name < policies[j].Name
+	})
+
+	// Namespace labels are only fetched once a policy needs them. Policies with invalid selectors are skipped, one
+	// broken policy mustn't keep every pod it's weighed against from being resolved
+	var namespace *corev1.Namespace
+	for i := range policies {
+		policy := &policies[i]
+		if matched, err := selectorMatches(policy.Spec.PodSelector, pod.Labels); err != nil {
+			log.FromContext(ctx).Error(err, fmt.Sprintf("Skipping disruption policy %s with an invalid podSelector", policy.Name))
+			continue
+		} else if !matched {
+			continue
+		}
+		if policy.Spec.NamespaceSelector != nil {
+			if namespace == nil {
+				namespace = &corev1.Namespace{}
+				if err := r.Client.Get(ctx, types.NamespacedName{Name: pod.Namespace}, namespace); err != nil {
+					return nil, fmt.Errorf("failed getting namespace %s: %w", pod.Namespace, err)
+				}
+			}
+			if matched, err := selectorMatches(policy.Spec.NamespaceSelector, namespace.Labels); err != nil {
+				log.FromContext(ctx).Error(err, fmt.Sprintf("Skipping disruption policy %s with an invalid namespaceSelector", policy.Name))
+				continue
+			} else if !matched {
+				continue
+			}
+		}
+		return policy, nil
+	}
+	return nil, nil
+}
+
+// selectorMatches treats a nil selector as matching everything.
+func selectorMatches(selector *metav1.LabelSelector, set map[string]string) (bool, error) {
+	if selector == nil {
+		return true, nil
+	}
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false, err
+	}
+	return s.Matches(labels.Set(set)), nil
+}
@@ -0,0 +1,169 @@
+package controller_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/apis/v1alpha1"
+	"github.com/jukie/karpenter-deprovision-controller/pkg/controller"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+func setupTestPolicy(name string, weight int32, spec v1alpha1.DisruptionPolicySpec) *v1alpha1.DisruptionPolicy {
+	spec.Weight = weight
+	return &v1alpha1.DisruptionPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       spec,
+	}
+}
+
+func TestWindowResolver_Resolve(t *testing.T) {
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "testing", Labels: map[string]string{"team": "payments"}}}
+	pod := setupTestPod("test-pod", "testing", "test-node", nil)
+	pod.Labels = map[string]string{"app": "api"}
+	annotatedPod := setupTestPod("test-pod", "testing", "test-node", map[string]string{
+		controller.DisruptionWindowSchedKey: "0 2 * * *",
+	})
+	annotatedPod.Labels = map[string]string{"app": "api"}
+	timezonePod := setupTestPod("test-pod", "testing", "test-node", map[string]string{
+		controller.DisruptionWindowSchedKey:    "0 2 * * *",
+		controller.DisruptionWindowTimezoneKey: "America/New_York",
+	})
+	timezoneOnlyPod := setupTestPod("test-pod", "testing", "test-node", map[string]string{
+		controller.DisruptionWindowTimezoneKey: "America/New_York",
+	})
+
+	nightly := setupTestPolicy("nightly", 0, v1alpha1.DisruptionPolicySpec{
+		Schedule: "0 1 * * *",
+		Duration: &metav1.Duration{Duration: 4 * time.Hour},
+		Timezone: "Europe/Berlin",
+	})
+	payments := setupTestPolicy("payments", 10, v1alpha1.DisruptionPolicySpec{
+		NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}},
+		Schedule:          "0 5 * * 6",
+	})
+	otherTeam := setupTestPolicy("other-team", 20, v1alpha1.DisruptionPolicySpec{
+		NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "search"}},
+		Schedule:          "0 6 * * 6",
+	})
+	apiDisabled := setupTestPolicy("api-disabled", 30, v1alpha1.DisruptionPolicySpec{
+		PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "api"}},
+		Enabled:     ptr.To(false),
+	})
+	invalidSelector := &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Like"}}}
+	invalidPodSelector := setupTestPolicy("invalid-pod-selector", 40, v1alpha1.DisruptionPolicySpec{
+		PodSelector: invalidSelector,
+		Schedule:    "0 3 * * *",
+	})
+	invalidNamespaceSelector := setupTestPolicy("invalid-namespace-selector", 50, v1alpha1.DisruptionPolicySpec{
+		NamespaceSelector: invalidSelector,
+		Schedule:          "0 4 * * *",
+	})
+
+	tests := []struct {
+		name     string
+		resolver bool
+		pod      *corev1.Pod
+		objList  []runtime.Object
+		want     controller.ResolvedWindow
+	}{
+		{
+			name: "No resolver only reads annotations",
+			pod:  annotatedPod,
+			want: controller.ResolvedWindow{Schedule: "0 2 * * *", Enabled: true},
+		},
+		{
+			name:     "No matching policies",
+			resolver: true,
+			pod:      pod,
+			objList:  []runtime.Object{namespace, otherTeam},
+			want:     controller.ResolvedWindow{Enabled: true},
+		},
+		{
+			name:     "Policy supplies window",
+			resolver: true,
+			pod:      pod,
+			objList:  []runtime.Object{namespace, nightly},
+			want:     controller.ResolvedWindow{Schedule: "0 1 * * *", Duration: "4h0m0s", Timezone: "Europe/Berlin", Enabled: true, Policy: "nightly"},
+		},
+		{
+			name:     "Highest weight matching namespace selector wins",
+			resolver: true,
+			pod:      pod,
+			objList:  []runtime.Object{namespace, nightly, payments, otherTeam},
+			want:     controller.ResolvedWindow{Schedule: "0 5 * * 6", Enabled: true, Policy: "payments"},
+		},
+		{
+			name:     "Annotations override policy values",
+			resolver: true,
+			pod:      timezonePod,
+			objList:  []runtime.Object{namespace, nightly},
+			want:     controller.ResolvedWindow{Schedule: "0 2 * * *", Duration: "4h0m0s", Timezone: "America/New_York", Enabled: true, Policy: "nightly"},
+		},
+		{
+			name:     "Annotation schedule without timezone is UTC",
+			resolver: true,
+			pod:      annotatedPod,
+			objList:  []runtime.Object{namespace, nightly},
+			want:     controller.ResolvedWindow{Schedule: "0 2 * * *", Duration: "4h0m0s", Enabled: true, Policy: "nightly"},
+		},
+		{
+			name:     "Annotation timezone applies to policy schedule",
+			resolver: true,
+			pod:      timezoneOnlyPod,
+			objList:  []runtime.Object{namespace, nightly},
+			want:     controller.ResolvedWindow{Schedule: "0 1 * * *", Duration: "4h0m0s", Timezone: "America/New_York", Enabled: true, Policy: "nightly"},
+		},
+		{
+			name:     "Policies with invalid selectors are skipped",
+			resolver: true,
+			pod:      pod,
+			objList:  []runtime.Object{namespace, nightly, invalidPodSelector, invalidNamespaceSelector},
+			want:     controller.ResolvedWindow{Schedule: "0 1 * * *", Duration: "4h0m0s", Timezone: "Europe/Berlin", Enabled: true, Policy: "nightly"},
+		},
+		{
+			name:     "Pod selector disables unblocking",
+			resolver: true,
+			pod:      pod,
+			objList:  []runtime.Object{namespace, nightly, apiDisabled},
+			want:     controller.ResolvedWindow{Enabled: false, Policy: "api-disabled"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resolver *controller.WindowResolver
+			if tt.resolver {
+				resolver = &controller.WindowResolver{Client: fake.NewClientBuilder().WithRuntimeObjects(tt.objList...).Build()}
+			}
+			got, err := resolver.Resolve(context.TODO(), tt.pod)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestHandleBlockingPods_DisabledPolicy(t *testing.T) {
+	pod := setupTestPod("blocking-no-sched", "testing", "test-node", map[string]string{karpv1.DoNotDisruptAnnotationKey: "true"})
+	disabled := setupTestPolicy("disabled", 0, v1alpha1.DisruptionPolicySpec{Enabled: ptr.To(false)})
+	fakeClient := fake.NewClientBuilder().WithRuntimeObjects(pod, disabled).Build()
+
+	deprovisionController := &controller.DeprovisionController{
+		Client:   fakeClient,
+		Resolver: &controller.WindowResolver{Client: fakeClient},
+	}
+	deprovisionController.HandleBlockingPods(context.TODO(), []corev1.Pod{*pod}, controller.BlockedNode{Name: "test-node", Reason: controller.ReasonExpired})
+
+	updatedPod := &corev1.Pod{}
+	assert.NoError(t, fakeClient.Get(context.TODO(), client.ObjectKeyFromObject(pod), updatedPod))
+	assert.Equal(t, "true", updatedPod.Annotations[karpv1.DoNotDisruptAnnotationKey], "Expected annotation to be unchanged")
+}
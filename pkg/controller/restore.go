@@ -0,0 +1,145 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/metrics"
+
+	"github.com/go-openapi/jsonpointer"
+	"github.com/prometheus/client_golang/prometheus"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlruntime "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+// RestoreController puts do-not-disrupt annotations back on pods whose node outlived the disruption window
+// the annotation was removed in, e.g. because Karpenter's disruption budget was exhausted.
+type RestoreController struct {
+	*DeprovisionController
+}
+
+func (c *RestoreController) Reconcile(ctx context.Context, pod *corev1.Pod) (reconcile.Result, error) {
+	defer metrics.ObserveReconcile("restore", time.Now())
+	config := c.currentConfig()
+	keys := config.Annotations.Keys()
+	original, markerKey, ok := keys.OriginalDoNotDisrupt.Get(pod.Annotations)
+	if !ok {
+		return reconcile.Result{}, nil
+	}
+	// Pods on their way out don't need protecting anymore
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning {
+		return reconcile.Result{}, nil
+	}
+
+	terminating, err := c.isNodeTerminating(ctx, pod.Spec.NodeName)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if terminating {
+		return reconcile.Result{}, nil
+	}
+
+	window, err := c.Resolver.ResolveWithKeys(ctx, pod, keys)
+	if err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed resolving disruption window for pod %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+	// Without a schedule the window never closes
+	parsed := parseDisruptionWindow(ctx, pod.Namespace, pod.Name, window.Schedule, window.Duration, window.Timezone, config.Windows)
+	if !parsed.ok {
+		return reconcile.Result{}, nil
+	}
+	now := time.Now()
+	if closesAt := parsed.window.ActiveUntil(now); !closesAt.IsZero() {
+		return reconcile.Result{RequeueAfter: closesAt.Sub(now)}, nil
+	}
+
+	// Pods unblocked by the max block duration would only be unblocked again
+	node, err := c.blockedNode(ctx, pod.Spec.NodeName, nil)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if limit, deadline := c.maxBlockDeadline(ctx, pod, node, now); limit > 0 && !now.Before(deadline) {
+		return reconcile.Result{}, nil
+	}
+
+	log.FromContext(ctx).Info(fmt.Sprintf("Disruption window closed before node %s was disrupted, restoring do-not-disrupt annotation on pod %s/%s", pod.Spec.NodeName, pod.Namespace, pod.Name))
+	return reconcile.Result{}, c.restoreAnnotation(ctx, pod, original, markerKey)
+}
+
+func (c *RestoreController) Register(_ context.Context, mgr manager.Manager) error {
+	return ctrlruntime.NewControllerManagedBy(mgr).
+		Named("deprovision-restore").
+		For(&corev1.Pod{}, builder.WithPredicates(predicate.NewPredicateFuncs(func(o client.Object) bool {
+			return c.currentConfig().Annotations.Keys().OriginalDoNotDisrupt.Has(o.GetAnnotations())
+		}))).
+		Complete(reconcile.AsReconciler(mgr.GetClient(), c))
+}
+
+// isNodeTerminating checks whether the node, or the NodeClaim behind it, has started terminating.
+func (c *RestoreController) isNodeTerminating(ctx context.Context, nodeName string) (bool, error) {
+	node := &corev1.Node{}
+	if err := c.Client.Get(ctx, types.NamespacedName{Name: nodeName}, node); err != nil {
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, fmt.Errorf("failed getting node %s: %w", nodeName, err)
+	}
+	if node.DeletionTimestamp != nil {
+		return true, nil
+	}
+	for _, taint := range node.Spec.Taints {
+		if karpv1.IsDisruptingTaint(taint) {
+			return true, nil
+		}
+	}
+
+	var ncList karpv1.NodeClaimList
+	if err := c.Client.List(ctx, &ncList, client.MatchingFields{"status.nodeName": nodeName}); err != nil {
+		return false, fmt.Errorf("failed getting nodeclaims from cache: %w", err)
+	}
+	for _, nc := range ncList.Items {
+		if nc.DeletionTimestamp != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// restoreAnnotation puts back the original do-not-disrupt value and drops the marker, which may be a deprecated key,
+// in a single patch. A do-not-disrupt annotation added by someone else in the meantime is left as is.
+func (c *RestoreController) restoreAnnotation(ctx context.Context, pod *corev1.Pod, original, marker string) error {
+	value, err := json.Marshal(original)
+	if err != nil {
+		return fmt.Errorf("failed encoding do-not-disrupt annotation for pod %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+	patch := fmt.Sprintf(`[{"op":"remove", "path":"/metadata/annotations/%s"}]`, jsonpointer.Escape(marker))
+	if _, ok := pod.Annotations[karpv1.DoNotDisruptAnnotationKey]; !ok {
+		patch = fmt.Sprintf(`[{"op":"add", "path":"/metadata/annotations/%s", "value":%s}, {"op":"remove", "path":"/metadata/annotations/%s"}]`,
+			jsonpointer.Escape(karpv1.DoNotDisruptAnnotationKey), value, jsonpointer.Escape(marker))
+	}
+	err = c.Client.Patch(ctx, pod, client.RawPatch(types.JSONPatchType, []byte(patch)))
+	if !c.DryRun {
+		metrics.PatchCounter.With(prometheus.Labels{
+			metrics.KindLabel:      "Pod",
+			metrics.NamespaceLabel: pod.Namespace,
+			metrics.OutcomeLabel:   metrics.Outcome(err),
+		}).Inc()
+	}
+	if err != nil {
+		return fmt.Errorf("failed restoring do-not-disrupt annotation on pod %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+	log.FromContext(ctx).Info(fmt.Sprintf("Annotation %s restored on pod %s in namespace %s", karpv1.DoNotDisruptAnnotationKey, pod.Name, pod.Namespace))
+	return nil
+}
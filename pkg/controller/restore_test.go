@@ -0,0 +1,129 @@
+package controller_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/clienthelpers"
+	"github.com/jukie/karpenter-deprovision-controller/pkg/controller"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+func TestHandleBlockingPods_RecordsOriginalAnnotation(t *testing.T) {
+	pod := setupTestPod("blocking-no-sched", "testing", "test-node", map[string]string{karpv1.DoNotDisruptAnnotationKey: "true"})
+	deprovisionController := &controller.DeprovisionController{Client: fake.NewClientBuilder().WithRuntimeObjects(pod).Build()}
+	deprovisionController.HandleBlockingPods(context.TODO(), []corev1.Pod{*pod}, controller.BlockedNode{Name: "test-node", Reason: controller.ReasonExpired})
+
+	updatedPod := &corev1.Pod{}
+	assert.NoError(t, deprovisionController.Client.Get(context.TODO(), types.NamespacedName{Name: pod.Name, Namespace: pod.Namespace}, updatedPod))
+	assert.NotContains(t, updatedPod.Annotations, karpv1.DoNotDisruptAnnotationKey)
+	assert.Equal(t, "true", updatedPod.Annotations[controller.OriginalDoNotDisruptKey])
+}
+
+func TestRestoreController_Reconcile(t *testing.T) {
+	closedSchedule := fmt.Sprintf("%d %d * * *", time.Now().UTC().Minute(), time.Now().Add(-4*time.Hour).UTC().Hour())
+	activeSchedule := fmt.Sprintf("%d %d * * *", time.Now().UTC().Minute(), time.Now().UTC().Hour())
+
+	unblockedPod := func(schedule string, phase corev1.PodPhase) *corev1.Pod {
+		pod := setupTestPod("unblocked", "testing", "test-node", map[string]string{
+			controller.OriginalDoNotDisruptKey:  "true",
+			controller.DisruptionWindowSchedKey: schedule,
+		})
+		pod.Status.Phase = phase
+		return pod
+	}
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "test-node"}}
+	disruptedNode := node.DeepCopy()
+	disruptedNode.Spec.Taints = []corev1.Taint{karpv1.DisruptedNoScheduleTaint}
+	deletingNodeClaim := setupTestNodeClaim("test-node", 3*time.Hour, "2h")
+	deletingNodeClaim.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	deletingNodeClaim.Finalizers = []string{karpv1.TerminationFinalizer}
+	overduePod := unblockedPod(closedSchedule, corev1.PodRunning)
+	overduePod.Annotations[controller.MaxBlockDurationKey] = "24h"
+
+	tests := []struct {
+		name          string
+		pod           *corev1.Pod
+		objList       []runtime.Object
+		expectRestore bool
+		expectRequeue bool
+	}{
+		{
+			name:          "Window closed on healthy node",
+			pod:           unblockedPod(closedSchedule, corev1.PodRunning),
+			objList:       []runtime.Object{node},
+			expectRestore: true,
+		},
+		{
+			name:          "Window still open",
+			pod:           unblockedPod(activeSchedule, corev1.PodRunning),
+			objList:       []runtime.Object{node},
+			expectRequeue: true,
+		},
+		{
+			name:    "Window without schedule never closes",
+			pod:     unblockedPod("", corev1.PodRunning),
+			objList: []runtime.Object{node},
+		},
+		{
+			name:    "Pod no longer running",
+			pod:     unblockedPod(closedSchedule, corev1.PodSucceeded),
+			objList: []runtime.Object{node},
+		},
+		{
+			name:    "Node tainted for disruption",
+			pod:     unblockedPod(closedSchedule, corev1.PodRunning),
+			objList: []runtime.Object{disruptedNode},
+		},
+		{
+			name:    "NodeClaim deleting",
+			pod:     unblockedPod(closedSchedule, corev1.PodRunning),
+			objList: []runtime.Object{node, deletingNodeClaim},
+		},
+		{
+			name:    "Max block duration exceeded",
+			pod:     overduePod,
+			objList: []runtime.Object{node, setupTestNodeClaim("test-node", 50*time.Hour, "2h")},
+		},
+		{
+			name: "Node gone",
+			pod:  unblockedPod(closedSchedule, corev1.PodRunning),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restoreController := &controller.RestoreController{
+				DeprovisionController: &controller.DeprovisionController{
+					Client: fake.NewClientBuilder().
+						WithRuntimeObjects(append(tt.objList, tt.pod)...).
+						WithIndex(&karpv1.NodeClaim{}, "status.nodeName", clienthelpers.NodeClaimIdxFunc).
+						Build(),
+				},
+			}
+
+			result, err := restoreController.Reconcile(context.TODO(), tt.pod)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectRequeue, result.RequeueAfter > 0)
+
+			updatedPod := &corev1.Pod{}
+			assert.NoError(t, restoreController.Client.Get(context.TODO(), types.NamespacedName{Name: tt.pod.Name, Namespace: tt.pod.Namespace}, updatedPod))
+			if tt.expectRestore {
+				assert.Equal(t, "true", updatedPod.Annotations[karpv1.DoNotDisruptAnnotationKey], "Expected annotation to be restored")
+				assert.NotContains(t, updatedPod.Annotations, controller.OriginalDoNotDisruptKey)
+			} else {
+				assert.NotContains(t, updatedPod.Annotations, karpv1.DoNotDisruptAnnotationKey, "Expected annotation to stay removed")
+				assert.Equal(t, "true", updatedPod.Annotations[controller.OriginalDoNotDisruptKey])
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package controller
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/apis/v1alpha1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// WindowValidation reports how the controller interprets a disruption window and the problems it would run into.
+type WindowValidation struct {
+	Schedule string `json:"schedule"`
+	Duration string `json:"duration,omitempty"`
+	Timezone string `json:"timezone,omitempty"`
+	// EffectiveDuration is the duration the controller uses after applying the configured default and minimum
+	EffectiveDuration *metav1.Duration `json:"effectiveDuration,omitempty"`
+	// Occurrences are the next windows after the time of validation
+	Occurrences []WindowOccurrence `json:"occurrences,omitempty"`
+	// Errors are values the controller can't use, it would silently fall back to a default for them
+	Errors []string `json:"errors,omitempty"`
+	// Warnings are values the controller adjusts or that are likely mistakes
+	Warnings []string `json:"warnings,omitempty"`
+	// durationTooShort is the warning about a duration below the minimum, which the webhook rejects in Deny mode
+	durationTooShort string
+}
+
+// WindowOccurrence is a single disruption window.
+type WindowOccurrence struct {
+	Start metav1.Time `json:"start"`
+	End   metav1.Time `json:"end"`
+}
+
+// Valid reports whether the controller can use every value as given.
+func (v WindowValidation) Valid() bool {
+	return len(v.Errors) == 0
+}
+
+// ValidateDisruptionWindow parses a disruption window the way the controller does and lists its next occurrences
+// after now, at most count of them.
+func ValidateDisruptionWindow(schedule, duration, timezone string, settings WindowSettings, count int, now time.Time) WindowValidation {
+	v := WindowValidation{Schedule: schedule, Duration: duration, Timezone: timezone}
+	if schedule == "" {
+		// The schedule may still come from a DisruptionPolicy, so the other values are checked on their own
+		if _, err := LoadTimezone(timezone); err != nil {
+			v.Errors = append(v.Errors, fmt.Sprintf("invalid timezone, windows evaluated in it are invalid: %s", err))
+		}
+		if _, err := time.ParseDuration(duration); duration != "" && err != nil {
+			v.Errors = append(v.Errors, fmt.Sprintf("invalid duration, the default of %s would be used: %s", settings.DefaultDuration.Duration, err))
+		}
+		if duration != "" || timezone != "" {
+			v.Warnings = append(v.Warnings, "there's no schedule, the duration and timezone only apply along with a DisruptionPolicy schedule")
+		}
+		return v
+	}
+	parsed := parseWindow(schedule, duration, timezone, settings)
+	if parsed.timezoneErr != nil {
+		v.Errors = append(v.Errors, fmt.Sprintf("invalid timezone, %s: %s", parsed.invalidPolicy.consequence("do-not-disrupt may be removed at any time"), parsed.timezoneErr))
+	}
+	if parsed.scheduleErr != nil {
+		v.Errors = append(v.Errors, fmt.Sprintf("invalid schedule, %s: %s", parsed.invalidPolicy.consequence("do-not-disrupt may be removed at any time"), parsed.scheduleErr))
+	}
+	if parsed.durationErr != nil {
+		v.Errors = append(v.Errors, fmt.Sprintf("invalid duration, %s: %s",
+			parsed.invalidPolicy.consequence(fmt.Sprintf("the default of %s would be used", settings.DefaultDuration.Duration)), parsed.durationErr))
+	}
+	if !parsed.ok {
+		return v
+	}
+	if parsed.durationTooShort {
+		v.durationTooShort = fmt.Sprintf("duration %s is shorter than the minimum of %s, the default of %s would be used",
+			duration, settings.MinimumDuration.Duration, parsed.window.Duration)
+		v.Warnings = append(v.Warnings, v.durationTooShort)
+	}
+	v.EffectiveDuration = &metav1.Duration{Duration: parsed.window.Duration}
+
+	for next := parsed.window.Schedule.Next(now); !next.IsZero() && len(v.Occurrences) < count; next = parsed.window.Schedule.Next(next) {
+		v.Occurrences = append(v.Occurrences, WindowOccurrence{
+			Start: metav1.Time{Time: next},
+			End:   metav1.Time{Time: next.Add(parsed.window.Duration)},
+		})
+	}
+	// Windows treated as closed never open on purpose
+	if parsed.window.Schedule.Next(now).IsZero() && parsed.invalidPolicy != InvalidWindowFailClosed {
+		v.Warnings = append(v.Warnings, "the schedule never fires, the window never opens")
+	}
+	return v
+}
+
+// ManifestValidation is the disruption window found in a Kubernetes manifest.
+type ManifestValidation struct {
+	// Source is the file the manifest was read from
+	Source    string `json:"source,omitempty"`
+	Kind      string `json:"kind,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	WindowValidation
+}
+
+// windowPaths are where the pod annotations of workload kinds live.
+var windowPaths = map[string][]string{
+	"Pod":                   {"metadata", "annotations"},
+	"PodTemplate":           {"template", "metadata", "annotations"},
+	"Deployment":            {"spec", "template", "metadata", "annotations"},
+	"StatefulSet":           {"spec", "template", "metadata", "annotations"},
+	"DaemonSet":             {"spec", "template", "metadata", "annotations"},
+	"ReplicaSet":            {"spec", "template", "metadata", "annotations"},
+	"ReplicationController": {"spec", "template", "metadata", "annotations"},
+	"Job":                   {"spec", "template", "metadata", "annotations"},
+	"CronJob":               {"spec", "jobTemplate", "spec", "template", "metadata", "annotations"},
+}
+
+// ScanManifests validates the disruption windows in a stream of YAML or JSON manifests, e.g. Helm output.
+// Pod annotations are read from pods and workload pod templates using the given keys, and DisruptionPolicies are
+// read from their spec. Lists are scanned item by item and manifests without a disruption window are skipped.
+func ScanManifests(r io.Reader, source string, keys AnnotationKeys, settings WindowSettings, count int, now time.Time) ([]ManifestValidation, error) {
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(r))
+	var results []ManifestValidation
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return results, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed reading %s: %w", source, err)
+		}
+		var content map[string]interface{}
+		if err := yaml.Unmarshal(doc, &content); err != nil {
+			return nil, fmt.Errorf("failed parsing %s: %w", source, err)
+		}
+		if content == nil {
+			continue
+		}
+		obj := &unstructured.Unstructured{Object: content}
+		if obj.IsList() {
+			err = obj.EachListItem(func(item runtime.Object) error {
+				results = appendManifestValidation(results, item.(*unstructured.Unstructured), source, keys, settings, count, now)
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed reading list in %s: %w", source, err)
+			}
+			continue
+		}
+		results = appendManifestValidation(results, obj, source, keys, settings, count, now)
+	}
+}
+
+func appendManifestValidation(results []ManifestValidation, obj *unstructured.Unstructured, source string, keys AnnotationKeys, settings WindowSettings, count int, now time.Time) []ManifestValidation {
+	var window ResolvedWindow
+	if obj.GroupVersionKind().Group == v1alpha1.GroupVersion.Group && obj.GetKind() == "DisruptionPolicy" {
+		window.Schedule, _, _ = unstructured.NestedString(obj.Object, "spec", "schedule")
+		window.Duration, _, _ = unstructured.NestedString(obj.Object, "spec", "duration")
+		window.Timezone, _, _ = unstructured.NestedString(obj.Object, "spec", "timezone")
+	} else {
+		window, _ = annotatedWindow(obj, keys)
+	}
+	if window == (ResolvedWindow{}) {
+		return results
+	}
+	return append(results, ManifestValidation{
+		Source:           source,
+		Kind:             obj.GetKind(),
+		Namespace:        obj.GetNamespace(),
+		Name:             obj.GetName(),
+		WindowValidation: ValidateDisruptionWindow(window.Schedule, window.Duration, window.Timezone, settings.ForNamespace(obj.GetNamespace()), count, now),
+	})
+}
+
+// annotatedWindow reads the disruption window annotations of a pod, or of the pods a workload creates. ok is false
+// for kinds without pods.
+func annotatedWindow(obj *unstructured.Unstructured, keys AnnotationKeys) (window ResolvedWindow, ok bool) {
+	path, ok := windowPaths[obj.GetKind()]
+	if !ok {
+		return window, false
+	}
+	annotations, _, _ := unstructured.NestedStringMap(obj.Object, path...)
+	window.Schedule, _, _ = keys.Schedule.Get(annotations)
+	window.Duration, _, _ = keys.Duration.Get(annotations)
+	window.Timezone, _, _ = keys.Timezone.Get(annotations)
+	return window, true
+}
@@ -0,0 +1,179 @@
+package controller_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/controller"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDisruptionWindow(t *testing.T) {
+	now := time.Date(2026, time.October, 16, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name              string
+		schedule          string
+		duration          string
+		timezone          string
+		policy            controller.InvalidWindowPolicy
+		wantValid         bool
+		wantWarnings      int
+		effectiveDuration time.Duration
+		firstStart        time.Time
+	}{
+		{
+			name:              "Valid",
+			schedule:          "0 2 * * 6",
+			duration:          "6h",
+			timezone:          "Europe/Berlin",
+			wantValid:         true,
+			effectiveDuration: 6 * time.Hour,
+			// 2am in Berlin is midnight UTC during daylight saving time
+			firstStart: time.Date(2026, time.October, 17, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:              "Duration below minimum",
+			schedule:          "0 2 * * *",
+			duration:          "1h",
+			wantValid:         true,
+			wantWarnings:      1,
+			effectiveDuration: 3 * time.Hour,
+			firstStart:        time.Date(2026, time.October, 17, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			name:              "Invalid duration",
+			schedule:          "0 2 * * *",
+			duration:          "two hours",
+			effectiveDuration: 3 * time.Hour,
+			firstStart:        time.Date(2026, time.October, 17, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "Invalid schedule",
+			schedule: "0 2 * * 61",
+		},
+		{
+			name:              "Invalid schedule failing closed",
+			schedule:          "0 2 * * 61",
+			policy:            controller.InvalidWindowFailClosed,
+			effectiveDuration: 3 * time.Hour,
+		},
+		{
+			name:     "Invalid timezone",
+			schedule: "0 2 * * *",
+			timezone: "Mars/Olympus",
+		},
+		{
+			name:              "Never fires",
+			schedule:          "0 0 30 2 *",
+			wantValid:         true,
+			wantWarnings:      1,
+			effectiveDuration: 3 * time.Hour,
+		},
+		{
+			name:         "Duration without schedule",
+			duration:     "6h",
+			wantValid:    true,
+			wantWarnings: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := controller.DefaultWindowSettings
+			if tt.policy != "" {
+				settings.InvalidPolicy = tt.policy
+			}
+			v := controller.ValidateDisruptionWindow(tt.schedule, tt.duration, tt.timezone, settings, 3, now)
+			assert.Equal(t, tt.wantValid, v.Valid(), "Errors: %v", v.Errors)
+			assert.Len(t, v.Warnings, tt.wantWarnings)
+			if tt.effectiveDuration == 0 {
+				assert.Nil(t, v.EffectiveDuration)
+			} else if assert.NotNil(t, v.EffectiveDuration) {
+				assert.Equal(t, tt.effectiveDuration, v.EffectiveDuration.Duration)
+			}
+			if tt.firstStart.IsZero() {
+				assert.Empty(t, v.Occurrences)
+			} else if assert.Len(t, v.Occurrences, 3) {
+				assert.True(t, tt.firstStart.Equal(v.Occurrences[0].Start.Time), "Expected first window at %s, got %s", tt.firstStart, v.Occurrences[0].Start)
+				assert.Equal(t, tt.effectiveDuration, v.Occurrences[0].End.Sub(v.Occurrences[0].Start.Time))
+			}
+		})
+	}
+}
+
+func TestScanManifests(t *testing.T) {
+	manifests := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+  namespace: payments
+spec:
+  template:
+    metadata:
+      annotations:
+        k8s.adsrvr.net/disruption-window-schedule: "0 2 * * 61"
+---
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: report
+spec:
+  jobTemplate:
+    spec:
+      template:
+        metadata:
+          annotations:
+            example.com/disruption-window-schedule: "0 2 * * *"
+            example.com/disruption-window-duration: "4h"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: unrelated
+---
+apiVersion: v1
+kind: List
+items:
+  - apiVersion: v1
+    kind: Pod
+    metadata:
+      name: worker
+      annotations:
+        example.com/disruption-window-timezone: Mars/Olympus
+---
+apiVersion: deprovision.jukie.dev/v1alpha1
+kind: DisruptionPolicy
+metadata:
+  name: weekend
+spec:
+  schedule: "0 2 * * 6"
+  duration: 6h
+`
+	keys := controller.AnnotationSettings{Prefix: "example.com", DeprecatedPrefixes: []string{controller.DefaultAnnotationPrefix}}.Keys()
+	results, err := controller.ScanManifests(strings.NewReader(manifests), "manifests.yaml", keys, controller.DefaultWindowSettings, 1, time.Now())
+	assert.NoError(t, err)
+	if !assert.Len(t, results, 4) {
+		return
+	}
+
+	assert.Equal(t, "Deployment", results[0].Kind)
+	assert.Equal(t, "payments", results[0].Namespace)
+	assert.Equal(t, "manifests.yaml", results[0].Source)
+	assert.False(t, results[0].Valid(), "Expected the deprecated schedule key to be read and rejected")
+
+	assert.Equal(t, "CronJob", results[1].Kind)
+	assert.True(t, results[1].Valid())
+	assert.Equal(t, 4*time.Hour, results[1].EffectiveDuration.Duration)
+
+	assert.Equal(t, "Pod", results[2].Kind)
+	assert.False(t, results[2].Valid())
+
+	assert.Equal(t, "DisruptionPolicy", results[3].Kind)
+	assert.True(t, results[3].Valid())
+	assert.Len(t, results[3].Occurrences, 1)
+
+	_, err = controller.ScanManifests(strings.NewReader("kind: [unterminated"), "broken.yaml", keys, controller.DefaultWindowSettings, 1, time.Now())
+	assert.Error(t, err)
+}
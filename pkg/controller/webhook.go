@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// WindowWebhookPath is where the disruption window webhook is served.
+const WindowWebhookPath = "/validate-disruption-window"
+
+// WebhookMode decides what the admission webhook does with invalid disruption windows.
+type WebhookMode string
+
+const (
+	// WebhookModeDeny rejects pods and workloads whose disruption window annotations the controller can't use
+	WebhookModeDeny WebhookMode = "Deny"
+	// WebhookModeWarn admits them with warnings, which kubectl shows to whoever applied them
+	WebhookModeWarn WebhookMode = "Warn"
+)
+
+// ParseWebhookMode parses Deny or Warn.
+func ParseWebhookMode(value string) (WebhookMode, error) {
+	switch mode := WebhookMode(value); mode {
+	case WebhookModeDeny, WebhookModeWarn:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown webhook mode %q, expected %s or %s", value, WebhookModeDeny, WebhookModeWarn)
+}
+
+// WindowWebhook validates the disruption window annotations of pods and the pod templates of workloads as they're
+// admitted. The controller falls back to defaults for values it can't parse, and an invalid schedule lets
+// do-not-disrupt be removed at any time, so a typo silently removes all protection without it.
+type WindowWebhook struct {
+	*DeprovisionController
+	Mode WebhookMode
+}
+
+// Register serves the webhook from the manager's webhook server.
+func (w *WindowWebhook) Register(_ context.Context, mgr manager.Manager) error {
+	mgr.GetWebhookServer().Register(WindowWebhookPath, &webhook.Admission{Handler: w})
+	return nil
+}
+
+func (w *WindowWebhook) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+	config := w.currentConfig()
+	if !config.Namespaces.Allows(req.Namespace) {
+		return admission.Allowed("namespace is not unblocked by the controller")
+	}
+	keys := config.Annotations.Keys()
+	kind := schema.GroupVersionKind(req.Kind)
+	window, ok, err := admittedWindow(req.Object.Raw, kind, keys)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if !ok || window == (ResolvedWindow{}) {
+		return admission.Allowed("")
+	}
+
+	v := ValidateDisruptionWindow(window.Schedule, window.Duration, window.Timezone, config.Windows.ForNamespace(req.Namespace), 0, time.Now())
+	problems, warnings := v.Errors, v.Warnings
+	if v.durationTooShort != "" {
+		problems = append(problems, v.durationTooShort)
+		warnings = slices.DeleteFunc(warnings, func(warning string) bool { return warning == v.durationTooShort })
+	}
+
+	result := metrics.ResultAllowed
+	response := admission.Allowed("")
+	switch {
+	case len(problems) == 0:
+	case w.Mode == WebhookModeWarn:
+		result = metrics.ResultWarned
+	case req.Operation == admissionv1.Update && unchangedWindow(req.OldObject.Raw, kind, keys, window):
+		// Objects admitted before the webhook existed must stay updatable, e.g. by the controller patching
+		// do-not-disrupt, so only changes to the window are rejected
+		result = metrics.ResultWarned
+	case req.Operation == admissionv1.Create && controlledPod(req.Object.Raw, kind):
+		// Pods created by a ReplicaSet or StatefulSet come from a template that was admitted on its own, possibly
+		// before the webhook existed. Rejecting them would keep the workload from replacing pods that were evicted
+		// or drained, which is exactly what unblocking leads to
+		result = metrics.ResultWarned
+	default:
+		result = metrics.ResultDenied
+		response = admission.Denied(fmt.Sprintf("invalid disruption window annotations %s: %s",
+			windowKeys(keys), strings.Join(problems, "; ")))
+	}
+	if result != metrics.ResultDenied {
+		for _, problem := range problems {
+			warnings = append(warnings, "invalid disruption window annotations: "+problem)
+		}
+	}
+	if result != metrics.ResultAllowed {
+		log.FromContext(ctx).Info("Disruption window annotations are invalid", "kind", kind.Kind, "namespace", req.Namespace,
+			"name", req.Name, "result", result, "problems", problems)
+	}
+	metrics.WebhookCounter.With(prometheus.Labels{
+		metrics.KindLabel:      kind.Kind,
+		metrics.NamespaceLabel: req.Namespace,
+		metrics.ResultLabel:    result,
+	}).Inc()
+	return response.WithWarnings(warnings...)
+}
+
+// admittedWindow reads the disruption window annotations from a raw admitted object. ok is false for kinds without
+// pods.
+func admittedWindow(raw []byte, kind schema.GroupVersionKind, keys AnnotationKeys) (window ResolvedWindow, ok bool, err error) {
+	if len(raw) == 0 {
+		return window, false, nil
+	}
+	obj := &unstructured.Unstructured{}
+	if err := json.Unmarshal(raw, &obj.Object); err != nil {
+		return window, false, fmt.Errorf("failed decoding %s: %w", kind.Kind, err)
+	}
+	obj.SetGroupVersionKind(kind)
+	window, ok = annotatedWindow(obj, keys)
+	return window, ok, nil
+}
+
+// controlledPod reports whether a raw admitted object is a pod with a controller, e.g. a ReplicaSet.
+func controlledPod(raw []byte, kind schema.GroupVersionKind) bool {
+	if kind.Group != "" || kind.Kind != "Pod" {
+		return false
+	}
+	obj := &unstructured.Unstructured{}
+	if err := json.Unmarshal(raw, &obj.Object); err != nil {
+		return false
+	}
+	return metav1.GetControllerOf(obj) != nil
+}
+
+// unchangedWindow reports whether an update leaves the disruption window annotations as they were.
+func unchangedWindow(oldRaw []byte, kind schema.GroupVersionKind, keys AnnotationKeys, window ResolvedWindow) bool {
+	old, ok, err := admittedWindow(oldRaw, kind, keys)
+	return err == nil && ok && old == window
+}
+
+func windowKeys(keys AnnotationKeys) string {
+	return fmt.Sprintf("(%s, %s, %s)", keys.Schedule.Name, keys.Duration.Name, keys.Timezone.Name)
+}
@@ -0,0 +1,258 @@
+package controller_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/controller"
+	"github.com/stretchr/testify/assert"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// setupTestDeployment returns a Deployment whose pod template has the given annotations
+func setupTestDeployment(name, namespace string, annotations map[string]string) *appsv1.Deployment {
+	labels := map[string]string{"app": name}
+	return &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels, Annotations: annotations},
+				Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app"}}},
+			},
+		},
+	}
+}
+
+func admissionRequest(t *testing.T, operation admissionv1.Operation, obj, old runtime.Object) admission.Request {
+	raw := func(obj runtime.Object) runtime.RawExtension {
+		if obj == nil {
+			return runtime.RawExtension{}
+		}
+		data, err := json.Marshal(obj)
+		assert.NoError(t, err)
+		return runtime.RawExtension{Raw: data}
+	}
+	gvk := obj.GetObjectKind().GroupVersionKind()
+	if gvk.Empty() {
+		gvk = corev1.SchemeGroupVersion.WithKind("Pod")
+	}
+	return admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: operation,
+		Kind:      metav1.GroupVersionKind(gvk),
+		Namespace: obj.(client.Object).GetNamespace(),
+		Name:      obj.(client.Object).GetName(),
+		Object:    raw(obj),
+		OldObject: raw(old),
+	}}
+}
+
+func TestWindowWebhook(t *testing.T) {
+	window := func(schedule, duration, timezone string) map[string]string {
+		annotations := map[string]string{}
+		for key, value := range map[string]string{
+			controller.DisruptionWindowSchedKey:    schedule,
+			controller.DisruptionWindowDurationKey: duration,
+			controller.DisruptionWindowTimezoneKey: timezone,
+		} {
+			if value != "" {
+				annotations[key] = value
+			}
+		}
+		return annotations
+	}
+
+	ownedPod := setupTestPod("api-7d9f8c6b5-x2x4k", "testing", "", window("0 2 * * 61", "", ""))
+	ownedPod.OwnerReferences = []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "api-7d9f8c6b5", Controller: ptr.To(true)}}
+
+	tests := []struct {
+		name         string
+		mode         controller.WebhookMode
+		operation    admissionv1.Operation
+		obj          runtime.Object
+		old          runtime.Object
+		wantAllowed  bool
+		wantWarnings int
+	}{
+		{
+			name:        "Valid window",
+			operation:   admissionv1.Create,
+			obj:         setupTestPod("valid", "testing", "", window("0 2 * * 6", "6h", "Europe/Berlin")),
+			wantAllowed: true,
+		},
+		{
+			name:        "Without window",
+			operation:   admissionv1.Create,
+			obj:         setupTestPod("plain", "testing", "", nil),
+			wantAllowed: true,
+		},
+		{
+			name:      "Invalid schedule",
+			operation: admissionv1.Create,
+			obj:       setupTestPod("invalid", "testing", "", window("0 2 * * 61", "", "")),
+		},
+		{
+			name:      "Duration below minimum",
+			operation: admissionv1.Create,
+			obj:       setupTestDeployment("short", "testing", window("0 2 * * *", "1h", "")),
+		},
+		{
+			name:         "Invalid timezone in warn mode",
+			mode:         controller.WebhookModeWarn,
+			operation:    admissionv1.Create,
+			obj:          setupTestDeployment("timezone", "testing", window("0 2 * * *", "", "Mars/Olympus")),
+			wantAllowed:  true,
+			wantWarnings: 1,
+		},
+		{
+			name:         "Never fires",
+			operation:    admissionv1.Create,
+			obj:          setupTestPod("february", "testing", "", window("0 0 30 2 *", "", "")),
+			wantAllowed:  true,
+			wantWarnings: 1,
+		},
+		{
+			name:         "Pod created by a ReplicaSet with an invalid window",
+			operation:    admissionv1.Create,
+			obj:          ownedPod,
+			wantAllowed:  true,
+			wantWarnings: 1,
+		},
+		{
+			name:         "Update keeping an invalid window",
+			operation:    admissionv1.Update,
+			obj:          setupTestPod("existing", "testing", "", window("0 2 * * 61", "", "")),
+			old:          setupTestPod("existing", "testing", "", window("0 2 * * 61", "", "")),
+			wantAllowed:  true,
+			wantWarnings: 1,
+		},
+		{
+			name:      "Update introducing an invalid window",
+			operation: admissionv1.Update,
+			obj:       setupTestPod("changed", "testing", "", window("0 2 * * 61", "", "")),
+			old:       setupTestPod("changed", "testing", "", window("0 2 * * 6", "", "")),
+		},
+		{
+			name:        "Excluded namespace",
+			operation:   admissionv1.Create,
+			obj:         setupTestPod("invalid", "kube-system", "", window("0 2 * * 61", "", "")),
+			wantAllowed: true,
+		},
+		{
+			name:        "Delete",
+			operation:   admissionv1.Delete,
+			obj:         setupTestPod("invalid", "testing", "", window("0 2 * * 61", "", "")),
+			wantAllowed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deprovisionController := &controller.DeprovisionController{}
+			config := controller.DefaultConfig()
+			config.Namespaces.Exclude = []string{"kube-system"}
+			deprovisionController.ApplyConfig(config)
+			mode := tt.mode
+			if mode == "" {
+				mode = controller.WebhookModeDeny
+			}
+			windowWebhook := &controller.WindowWebhook{DeprovisionController: deprovisionController, Mode: mode}
+
+			response := windowWebhook.Handle(context.TODO(), admissionRequest(t, tt.operation, tt.obj, tt.old))
+			assert.Equal(t, tt.wantAllowed, response.Allowed, "Result: %v", response.Result)
+			assert.Len(t, response.Warnings, tt.wantWarnings, "Warnings: %v", response.Warnings)
+			if !tt.wantAllowed {
+				assert.Contains(t, response.Result.Message, controller.DisruptionWindowSchedKey)
+			}
+		})
+	}
+}
+
+func TestParseWebhookMode(t *testing.T) {
+	mode, err := controller.ParseWebhookMode("Warn")
+	assert.NoError(t, err)
+	assert.Equal(t, controller.WebhookModeWarn, mode)
+
+	_, err = controller.ParseWebhookMode("warn")
+	assert.Error(t, err)
+}
+
+// TestWindowWebhook_Envtest admits objects through a real API server using the ValidatingWebhookConfiguration in
+// configs. It needs the envtest binaries, e.g. KUBEBUILDER_ASSETS=$(setup-envtest use -p path 1.31.x)
+func TestWindowWebhook_Envtest(t *testing.T) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		t.Skip("KUBEBUILDER_ASSETS isn't set, install the envtest binaries with setup-envtest to run this test")
+	}
+	env := &envtest.Environment{
+		WebhookInstallOptions: envtest.WebhookInstallOptions{Paths: []string{"../../configs/ValidatingWebhook.yaml"}},
+	}
+	restConfig, err := env.Start()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { assert.NoError(t, env.Stop()) }()
+
+	serving := env.WebhookInstallOptions
+	mgr, err := manager.New(restConfig, manager.Options{
+		Metrics: metricsserver.Options{BindAddress: "0"},
+		WebhookServer: webhook.NewServer(webhook.Options{
+			Host:    serving.LocalServingHost,
+			Port:    serving.LocalServingPort,
+			CertDir: serving.LocalServingCertDir,
+		}),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	windowWebhook := &controller.WindowWebhook{DeprovisionController: &controller.DeprovisionController{Client: mgr.GetClient()}, Mode: controller.WebhookModeDeny}
+	assert.NoError(t, windowWebhook.Register(context.TODO(), mgr))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		assert.NoError(t, mgr.Start(ctx))
+	}()
+	started := mgr.GetWebhookServer().StartedChecker()
+	assert.Eventually(t, func() bool { return started(&http.Request{}) == nil }, 10*time.Second, 100*time.Millisecond)
+
+	c, err := client.New(restConfig, client.Options{})
+	if !assert.NoError(t, err) {
+		return
+	}
+	valid := setupTestDeployment("valid", "default", map[string]string{
+		controller.DisruptionWindowSchedKey:    "0 2 * * 6",
+		controller.DisruptionWindowDurationKey: "6h",
+	})
+	assert.NoError(t, c.Create(ctx, valid))
+
+	invalid := setupTestDeployment("invalid", "default", map[string]string{controller.DisruptionWindowSchedKey: "0 2 * * 61"})
+	err = c.Create(ctx, invalid)
+	assert.True(t, apierrors.IsForbidden(err), "Expected the webhook to deny the deployment, got %v", err)
+
+	valid.Spec.Template.Annotations[controller.DisruptionWindowDurationKey] = "1h"
+	err = c.Update(ctx, valid)
+	assert.True(t, apierrors.IsForbidden(err), "Expected the webhook to deny the update, got %v", err)
+
+	pod := setupTestPod("invalid", "default", "", map[string]string{controller.DisruptionWindowTimezoneKey: "Mars/Olympus"})
+	pod.Spec.Containers = []corev1.Container{{Name: "app", Image: "app"}}
+	err = c.Create(ctx, pod)
+	assert.True(t, apierrors.IsForbidden(err), "Expected the webhook to deny the pod, got %v", err)
+}
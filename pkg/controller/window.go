@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// DisruptionWindow is a parsed disruption window schedule.
+type DisruptionWindow struct {
+	Schedule cron.Schedule
+	Duration time.Duration
+}
+
+// InvalidWindowPolicy decides how a disruption window with an invalid schedule, duration or timezone is treated.
+type InvalidWindowPolicy string
+
+const (
+	// InvalidWindowFailOpen treats invalid schedules and timezones as always active, so do-not-disrupt may be removed at
+	// any time. Invalid durations get the default duration.
+	InvalidWindowFailOpen InvalidWindowPolicy = "FailOpen"
+	// InvalidWindowFailClosed treats invalid windows as never active, so do-not-disrupt is only removed once the max
+	// block duration is exceeded
+	InvalidWindowFailClosed InvalidWindowPolicy = "FailClosed"
+	// InvalidWindowDefault replaces invalid windows with the configured default window
+	InvalidWindowDefault InvalidWindowPolicy = "DefaultWindow"
+)
+
+// ParseInvalidWindowPolicy parses FailOpen, FailClosed or DefaultWindow.
+func ParseInvalidWindowPolicy(value string) (InvalidWindowPolicy, error) {
+	switch policy := InvalidWindowPolicy(value); policy {
+	case InvalidWindowFailOpen, InvalidWindowFailClosed, InvalidWindowDefault:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown invalid window policy %q, expected %s, %s or %s", value, InvalidWindowFailOpen, InvalidWindowFailClosed, InvalidWindowDefault)
+}
+
+// consequence describes what happens to an invalid value under the policy, failOpen being what FailOpen does for it.
+func (p InvalidWindowPolicy) consequence(failOpen string) string {
+	switch p {
+	case InvalidWindowFailClosed:
+		return "treating the window as closed"
+	case InvalidWindowDefault:
+		return "using the default window"
+	}
+	return failOpen
+}
+
+// neverSchedule never hits, making the windows of the FailClosed policy never active.
+type neverSchedule struct{}
+
+func (neverSchedule) Next(time.Time) time.Time {
+	return time.Time{}
+}
+
+// ActiveAt walks back in time for the window duration and checks if the schedule hit between then and t.
+// Schedules carry their own location so DST transitions are handled by the cron package.
+// Schedules that never hit, e.g. on February 30th, are never active.
+func (w DisruptionWindow) ActiveAt(t time.Time) bool {
+	checkPoint := t.Add(-w.Duration)
+	nextHit := w.Schedule.Next(checkPoint)
+	return !nextHit.IsZero() && !nextHit.After(t)
+}
+
+// ActiveUntil returns when the window containing t closes, or the zero time if t is outside a window.
+// Overlapping schedule hits extend the window, so the latest hit that covers t decides the end.
+func (w DisruptionWindow) ActiveUntil(t time.Time) time.Time {
+	var lastHit time.Time
+	for hit := w.Schedule.Next(t.Add(-w.Duration)); !hit.IsZero() && !hit.After(t); hit = w.Schedule.Next(hit) {
+		lastHit = hit
+	}
+	if lastHit.IsZero() {
+		return time.Time{}
+	}
+	return lastHit.Add(w.Duration)
+}
+
+// LoadTimezone validates an IANA timezone name, an empty name means UTC.
+// "Local" is rejected since it would depend on the controller's host configuration.
+func LoadTimezone(tz string) (*time.Location, error) {
+	if tz == "" {
+		return time.UTC, nil
+	}
+	if tz == "Local" {
+		return nil, fmt.Errorf("timezone %q is not supported, use an IANA timezone name", tz)
+	}
+	location, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q: %w", tz, err)
+	}
+	return location, nil
+}
+
+// ParseSchedule parses a standard cron expression evaluated in the given location.
+func ParseSchedule(sched string, location *time.Location) (cron.Schedule, error) {
+	return cron.ParseStandard(fmt.Sprintf("TZ=%s %s", location.String(), sched))
+}
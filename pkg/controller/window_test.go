@@ -0,0 +1,262 @@
+package controller_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/controller"
+	"github.com/stretchr/testify/assert"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	karpv1 "sigs.k8s.io/karpenter/pkg/apis/v1"
+)
+
+func TestLoadTimezone(t *testing.T) {
+	tests := []struct {
+		tz      string
+		want    string
+		wantErr bool
+	}{
+		{tz: "", want: "UTC"},
+		{tz: "America/New_York", want: "America/New_York"},
+		{tz: "Mars/Olympus_Mons", wantErr: true},
+		{tz: "Local", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tz, func(t *testing.T) {
+			location, err := controller.LoadTimezone(tt.tz)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, location.String())
+		})
+	}
+}
+
+func TestDisruptionWindow_ActiveAt(t *testing.T) {
+	newYork, err := controller.LoadTimezone("America/New_York")
+	assert.NoError(t, err)
+	schedule, err := controller.ParseSchedule("0 9 * * *", newYork)
+	assert.NoError(t, err)
+	window := controller.DisruptionWindow{Schedule: schedule, Duration: 3 * time.Hour}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want bool
+	}{
+		{
+			name: "Winter opens at 09:00 EST",
+			at:   time.Date(2026, 1, 15, 14, 30, 0, 0, time.UTC),
+			want: true,
+		},
+		{
+			name: "Summer opens at 09:00 EDT",
+			at:   time.Date(2026, 7, 15, 13, 30, 0, 0, time.UTC),
+			want: true,
+		},
+		{
+			name: "Summer is closed at 08:30 EDT even though it's 09:00 EST",
+			at:   time.Date(2026, 7, 15, 12, 30, 0, 0, time.UTC),
+			want: false,
+		},
+		{
+			name: "Day of spring forward uses EDT",
+			at:   time.Date(2026, 3, 8, 13, 0, 0, 0, time.UTC),
+			want: true,
+		},
+		{
+			name: "Day of fall back closes three hours after 09:00 EST",
+			at:   time.Date(2026, 11, 1, 17, 0, 0, 0, time.UTC),
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, window.ActiveAt(tt.at))
+		})
+	}
+}
+
+func TestDisruptionWindow_NeverHits(t *testing.T) {
+	schedule, err := controller.ParseSchedule("0 0 30 2 *", time.UTC)
+	assert.NoError(t, err)
+	window := controller.DisruptionWindow{Schedule: schedule, Duration: 3 * time.Hour}
+
+	assert.False(t, window.ActiveAt(time.Now()))
+	assert.True(t, window.ActiveUntil(time.Now()).IsZero())
+}
+
+func TestHandleBlockingPods_InvalidWindowPolicy(t *testing.T) {
+	const activeSchedule = "* * * * *"
+	inactiveSchedule := fmt.Sprintf("%d %d * * *", time.Now().UTC().Minute(), time.Now().Add(-4*time.Hour).UTC().Hour())
+
+	tests := []struct {
+		name               string
+		policy             controller.InvalidWindowPolicy
+		namespacePolicies  map[string]controller.InvalidWindowPolicy
+		defaultSchedule    string
+		schedule           string
+		duration           string
+		timezone           string
+		maxBlockDuration   time.Duration
+		expectRemoved      bool
+		expectInvalidEvent string
+	}{
+		{
+			name:               "Fail open on invalid schedule",
+			policy:             controller.InvalidWindowFailOpen,
+			schedule:           "not a schedule",
+			expectRemoved:      true,
+			expectInvalidEvent: "schedule \"not a schedule\" is invalid, karpenter.sh/do-not-disrupt may be removed at any time",
+		},
+		{
+			name:               "Fail open on invalid duration uses the default duration",
+			policy:             controller.InvalidWindowFailOpen,
+			schedule:           inactiveSchedule,
+			duration:           "two hours",
+			expectInvalidEvent: "duration \"two hours\" is invalid, using the default duration",
+		},
+		{
+			name:               "Fail open on invalid timezone is always active",
+			policy:             controller.InvalidWindowFailOpen,
+			schedule:           inactiveSchedule,
+			timezone:           "Mars/Olympus",
+			expectRemoved:      true,
+			expectInvalidEvent: "timezone \"Mars/Olympus\" is invalid, karpenter.sh/do-not-disrupt may be removed at any time",
+		},
+		{
+			name:               "Fail closed on invalid schedule",
+			policy:             controller.InvalidWindowFailClosed,
+			schedule:           "not a schedule",
+			expectInvalidEvent: "schedule \"not a schedule\" is invalid, treating the window as closed",
+		},
+		{
+			name:               "Fail closed on invalid duration",
+			policy:             controller.InvalidWindowFailClosed,
+			schedule:           activeSchedule,
+			duration:           "two hours",
+			expectInvalidEvent: "treating the window as closed",
+		},
+		{
+			name:               "Fail closed on invalid timezone",
+			policy:             controller.InvalidWindowFailClosed,
+			schedule:           activeSchedule,
+			timezone:           "Mars/Olympus",
+			expectInvalidEvent: "treating the window as closed",
+		},
+		{
+			name:          "Fail closed leaves valid windows alone",
+			policy:        controller.InvalidWindowFailClosed,
+			schedule:      activeSchedule,
+			duration:      "4h",
+			expectRemoved: true,
+		},
+		{
+			name:               "Fail closed still honors the max block duration",
+			policy:             controller.InvalidWindowFailClosed,
+			schedule:           "not a schedule",
+			maxBlockDuration:   24 * time.Hour,
+			expectRemoved:      true,
+			expectInvalidEvent: "treating the window as closed",
+		},
+		{
+			name:               "Active default window",
+			policy:             controller.InvalidWindowDefault,
+			defaultSchedule:    activeSchedule,
+			schedule:           "not a schedule",
+			expectRemoved:      true,
+			expectInvalidEvent: "schedule \"not a schedule\" is invalid, using the default window",
+		},
+		{
+			name:               "Inactive default window",
+			policy:             controller.InvalidWindowDefault,
+			defaultSchedule:    inactiveSchedule,
+			schedule:           activeSchedule,
+			duration:           "two hours",
+			expectInvalidEvent: "using the default window",
+		},
+		{
+			name:               "Namespace policy overrides global policy",
+			policy:             controller.InvalidWindowFailOpen,
+			namespacePolicies:  map[string]controller.InvalidWindowPolicy{"testing": controller.InvalidWindowFailClosed},
+			schedule:           "not a schedule",
+			expectInvalidEvent: "treating the window as closed",
+		},
+		{
+			name:               "Policy of other namespaces doesn't apply",
+			policy:             controller.InvalidWindowFailOpen,
+			namespacePolicies:  map[string]controller.InvalidWindowPolicy{"compliance": controller.InvalidWindowFailClosed},
+			schedule:           "not a schedule",
+			expectRemoved:      true,
+			expectInvalidEvent: "may be removed at any time",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			annotations := map[string]string{karpv1.DoNotDisruptAnnotationKey: "true"}
+			for key, value := range map[string]string{
+				controller.DisruptionWindowSchedKey:    tt.schedule,
+				controller.DisruptionWindowDurationKey: tt.duration,
+				controller.DisruptionWindowTimezoneKey: tt.timezone,
+			} {
+				if value != "" {
+					annotations[key] = value
+				}
+			}
+			pod := setupTestPod("blocking", "testing", "test-node", annotations)
+			config := controller.DefaultConfig()
+			config.Windows.InvalidPolicy = tt.policy
+			config.Windows.NamespaceInvalidPolicies = tt.namespacePolicies
+			config.Windows.DefaultSchedule = tt.defaultSchedule
+			assert.NoError(t, config.Validate())
+			recorder := record.NewFakeRecorder(10)
+			deprovisionController := &controller.DeprovisionController{
+				Client:           fake.NewClientBuilder().WithRuntimeObjects(pod).Build(),
+				MaxBlockDuration: tt.maxBlockDuration,
+				Recorder:         recorder,
+			}
+			deprovisionController.ApplyConfig(config)
+			deprovisionController.HandleBlockingPods(context.TODO(), []corev1.Pod{*pod}, setupBlockedNode("test-node", "default", 48*time.Hour))
+
+			updatedPod := &corev1.Pod{}
+			assert.NoError(t, deprovisionController.Client.Get(context.TODO(), client.ObjectKeyFromObject(pod), updatedPod))
+			if tt.expectRemoved {
+				assert.NotContains(t, updatedPod.Annotations, karpv1.DoNotDisruptAnnotationKey, "Expected annotation to be removed")
+			} else {
+				assert.Equal(t, "true", updatedPod.Annotations[karpv1.DoNotDisruptAnnotationKey], "Expected annotation to be unchanged")
+			}
+
+			close(recorder.Events)
+			var invalidEvents []string
+			for event := range recorder.Events {
+				if strings.Contains(event, controller.InvalidDisruptionWindowEventReason) {
+					invalidEvents = append(invalidEvents, event)
+				}
+			}
+			if tt.expectInvalidEvent == "" {
+				assert.Empty(t, invalidEvents)
+			} else if assert.Len(t, invalidEvents, 1) {
+				assert.Contains(t, invalidEvents[0], tt.expectInvalidEvent)
+			}
+		})
+	}
+}
+
+func TestParseInvalidWindowPolicy(t *testing.T) {
+	policy, err := controller.ParseInvalidWindowPolicy("FailClosed")
+	assert.NoError(t, err)
+	assert.Equal(t, controller.InvalidWindowFailClosed, policy)
+
+	_, err = controller.ParseInvalidWindowPolicy("fail-closed")
+	assert.Error(t, err)
+}
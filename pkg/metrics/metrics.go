@@ -1,17 +1,49 @@
 package metrics
 
 import (
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
+// Labels are kept to bounded sets of values, pods and nodes are never used as label values.
 const (
 	Namespace      = "karpenter"
 	Subsystem      = "disruption_controller"
 	KindLabel      = "kind"
-	NameLabel      = "name"
+	NamespaceLabel = "namespace"
+	OutcomeLabel   = "outcome"
 	AnnotationType = "type"
-	SucceededLabel = "succeeded"
+	BlackoutLabel  = "blackout"
+	TriggerLabel   = "trigger"
+	// EventReasonLabel is the reason of a Kubernetes Event
+	EventReasonLabel = "reason"
+	// DisruptionReasonLabel is why a node is being disrupted, e.g. Expired
+	DisruptionReasonLabel = "disruption_reason"
+	NodePoolLabel         = "nodepool"
+	ControllerLabel       = "controller"
+	// HashLabel identifies a configuration version
+	HashLabel = "hash"
+	// AnnotationLabel is a pod annotation key
+	AnnotationLabel = "annotation"
+	// ResultLabel is what the admission webhook decided
+	ResultLabel = "result"
+
+	// TriggerWindow marks pods unblocked inside their disruption window
+	TriggerWindow = "window"
+	// TriggerMaxBlockDuration marks pods unblocked because they exceeded their max block duration
+	TriggerMaxBlockDuration = "max_block_duration"
+
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	// OutcomeBlocked marks evictions refused because of a PodDisruptionBudget
+	OutcomeBlocked = "blocked"
+
+	ResultAllowed = "allowed"
+	// ResultWarned marks requests admitted with warnings about their disruption window
+	ResultWarned = "warned"
+	ResultDenied = "denied"
 )
 
 var (
@@ -20,12 +52,24 @@ var (
 			Namespace: Namespace,
 			Subsystem: Subsystem,
 			Name:      "patch_operations_total",
-			Help:      "Number of patch events in total by Karpenter Disruption Controller. Labeled by resource kind, resource name, and success status.",
+			Help:      "Number of patches applied by Karpenter Disruption Controller. Labeled by resource kind, namespace and outcome.",
 		},
 		[]string{
 			KindLabel,
-			NameLabel,
-			SucceededLabel,
+			NamespaceLabel,
+			OutcomeLabel,
+		},
+	)
+	EvictionCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "evictions_total",
+			Help:      "Number of evictions of unblocked pods from expired nodes. Labeled by namespace and outcome, blocked meaning a pod disruption budget refused the eviction.",
+		},
+		[]string{
+			NamespaceLabel,
+			OutcomeLabel,
 		},
 	)
 	FailedAnnotationParseCounter = prometheus.NewCounterVec(
@@ -33,15 +77,153 @@ var (
 			Namespace: Namespace,
 			Subsystem: Subsystem,
 			Name:      "annotation_parse_failed",
-			Help:      "Number of annotation parsing failures in total by Karpenter Disruption Controller. Labeled by annotation type and pod name.",
+			Help:      "Number of annotation parsing failures in total by Karpenter Disruption Controller. Labeled by annotation type and namespace.",
 		},
 		[]string{
 			AnnotationType,
-			NameLabel,
+			NamespaceLabel,
+		},
+	)
+	BlackoutSkipCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "blackout_skipped_total",
+			Help:      "Number of times a blocking pod was left alone because a blackout was active. Labeled by blackout name and namespace.",
+		},
+		[]string{
+			BlackoutLabel,
+			NamespaceLabel,
+		},
+	)
+	UnblockedPodsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "pods_unblocked_total",
+			Help:      "Number of pods that had their do-not-disrupt annotation removed. Labeled by namespace and what triggered the removal.",
+		},
+		[]string{
+			NamespaceLabel,
+			TriggerLabel,
+		},
+	)
+	UnmatchedEventCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "events_unmatched_total",
+			Help:      "Number of cached events that matched no event matcher, e.g. because a Karpenter upgrade changed its event messages. Labeled by involved object kind and event reason.",
+		},
+		[]string{
+			KindLabel,
+			EventReasonLabel,
+		},
+	)
+	DeprecatedAnnotationCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "deprecated_annotations_total",
+			Help:      "Number of times a pod annotation was read from a deprecated key. Labeled by annotation key and namespace.",
+		},
+		[]string{
+			AnnotationLabel,
+			NamespaceLabel,
 		},
 	)
+	UnblockLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "unblock_latency_seconds",
+			Help:      "Seconds from a node starting to be disrupted, e.g. expiring, until a blocking pod on it was unblocked. Labeled by disruption reason.",
+			// One minute to roughly two weeks
+			Buckets: prometheus.ExponentialBuckets(60, 2, 15),
+		},
+		[]string{
+			DisruptionReasonLabel,
+		},
+	)
+	ReconcileDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "reconcile_duration_seconds",
+			Help:      "Seconds spent reconciling. Labeled by controller.",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{
+			ControllerLabel,
+		},
+	)
+	WebhookCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "webhook_requests_total",
+			Help:      "Number of pods and workloads with disruption window annotations reviewed by the admission webhook. Labeled by kind, namespace and result.",
+		},
+		[]string{
+			KindLabel,
+			NamespaceLabel,
+			ResultLabel,
+		},
+	)
+	ConfigInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "config_info",
+			Help:      "Always 1, labeled by the hash of the configuration currently applied.",
+		},
+		[]string{
+			HashLabel,
+		},
+	)
+	ConfigReloadFailureCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: Namespace,
+			Subsystem: Subsystem,
+			Name:      "config_reload_failures_total",
+			Help:      "Number of times a changed configuration file or blackout calendar was rejected and the previous one kept.",
+		},
+	)
+
+	BlockedNodesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, Subsystem, "blocked_nodes"),
+		"Number of nodes being disrupted that still have pods with a do-not-disrupt annotation. Labeled by NodePool.",
+		[]string{NodePoolLabel}, nil,
+	)
+	BlockingPodsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(Namespace, Subsystem, "blocking_pods"),
+		"Number of pods with a do-not-disrupt annotation on nodes being disrupted. Labeled by NodePool.",
+		[]string{NodePoolLabel}, nil,
+	)
 )
 
-func Register() {
-	ctrlmetrics.Registry.MustRegister(PatchCounter, FailedAnnotationParseCounter)
+// Outcome returns the outcome label value for an operation that returned err.
+func Outcome(err error) string {
+	if err != nil {
+		return OutcomeFailure
+	}
+	return OutcomeSuccess
+}
+
+// ObserveReconcile records how long the named controller has been reconciling since start, meant to be deferred.
+func ObserveReconcile(controller string, start time.Time) {
+	ReconcileDuration.With(prometheus.Labels{ControllerLabel: controller}).Observe(time.Since(start).Seconds())
+}
+
+// SetConfigInfo reports the hash of the configuration currently applied.
+func SetConfigInfo(hash string) {
+	ConfigInfo.Reset()
+	ConfigInfo.With(prometheus.Labels{HashLabel: hash}).Set(1)
+}
+
+// Register registers the controller's metrics along with collectors computing gauges at scrape time.
+func Register(collectors ...prometheus.Collector) {
+	ctrlmetrics.Registry.MustRegister(PatchCounter, EvictionCounter, FailedAnnotationParseCounter, BlackoutSkipCounter, UnblockedPodsCounter,
+		UnmatchedEventCounter, DeprecatedAnnotationCounter, UnblockLatency, ReconcileDuration, WebhookCounter, ConfigInfo, ConfigReloadFailureCounter)
+	ctrlmetrics.Registry.MustRegister(collectors...)
 }
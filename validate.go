@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jukie/karpenter-deprovision-controller/pkg/controller"
+)
+
+const validateUsage = `Usage: %s validate [flags] --schedule SCHEDULE [--duration DURATION] [--timezone TIMEZONE]
+       %s validate [flags] FILE...
+
+Validates disruption windows the way the controller parses them, reporting errors, the effective duration and the
+next windows. Windows are given with --schedule, --duration and --timezone, or read from the pod annotations of
+workloads and from DisruptionPolicies in manifest files, e.g. Helm output. Use - to read from stdin. Pass the
+controller's --annotation-prefix or --config so the same keys and duration limits apply.
+
+Exits with 1 when any window has errors, so it can be used in CI.
+
+Flags:
+`
+
+// validate runs the validate subcommand and returns the exit code.
+func validate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	registerFlags(fs)
+	var schedule, duration, timezone, output string
+	var count int
+	fs.StringVar(&schedule, "schedule", "", "Disruption window schedule in cron format")
+	fs.StringVar(&duration, "duration", "", "Disruption window duration, e.g. 6h")
+	fs.StringVar(&timezone, "timezone", "", "IANA timezone the schedule is evaluated in. Defaults to UTC")
+	fs.IntVar(&count, "count", 3, "Number of upcoming windows to list")
+	fs.StringVar(&output, "output", "table", "Output format: table or json")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), validateUsage, filepath.Base(os.Args[0]), filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+		return 0
+	} else if err != nil {
+		return 2
+	}
+	flagWindow := schedule != "" || duration != "" || timezone != ""
+	if flagWindow == (fs.NArg() > 0) || (output != "table" && output != "json") {
+		fs.Usage()
+		return 2
+	}
+
+	controllerConfig, _ := loadControllerConfig(context.Background())
+	keys := controllerConfig.Annotations.Keys()
+	now := time.Now()
+	var results []controller.ManifestValidation
+	if flagWindow {
+		results = append(results, controller.ManifestValidation{
+			WindowValidation: controller.ValidateDisruptionWindow(schedule, duration, timezone, controllerConfig.Windows, count, now),
+		})
+	}
+	for _, path := range fs.Args() {
+		scanned, err := scanManifestFile(path, keys, controllerConfig.Windows, count, now)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		results = append(results, scanned...)
+	}
+
+	if output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if results == nil {
+			results = []controller.ManifestValidation{}
+		}
+		if err := encoder.Encode(results); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	} else {
+		printValidations(os.Stdout, results)
+	}
+	for _, result := range results {
+		if !result.Valid() {
+			return 1
+		}
+	}
+	return 0
+}
+
+// scanManifestFile scans a manifest file, or stdin for -.
+func scanManifestFile(path string, keys controller.AnnotationKeys, settings controller.WindowSettings, count int, now time.Time) ([]controller.ManifestValidation, error) {
+	if path == "-" {
+		return controller.ScanManifests(os.Stdin, "<stdin>", keys, settings, count, now)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening %s: %w", path, err)
+	}
+	defer f.Close()
+	return controller.ScanManifests(f, path, keys, settings, count, now)
+}
+
+// printValidations writes a block per window followed by a summary.
+func printValidations(out io.Writer, results []controller.ManifestValidation) {
+	invalid := 0
+	for i, result := range results {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		if result.Kind != "" {
+			name := result.Name
+			if result.Namespace != "" {
+				name = result.Namespace + "/" + name
+			}
+			fmt.Fprintf(out, "%s: %s %s\n", result.Source, result.Kind, name)
+		}
+		timezone := result.Timezone
+		if timezone == "" {
+			timezone = "UTC"
+		}
+		fmt.Fprintf(out, "  Schedule:  %s (%s)\n", orNone(result.Schedule), timezone)
+		if result.EffectiveDuration != nil {
+			fmt.Fprintf(out, "  Duration:  %s, effective %s\n", orNone(result.Duration), result.EffectiveDuration.Duration)
+		} else {
+			fmt.Fprintf(out, "  Duration:  %s\n", orNone(result.Duration))
+		}
+		if len(result.Occurrences) > 0 {
+			fmt.Fprintf(out, "  Next windows:\n")
+			for _, occurrence := range result.Occurrences {
+				fmt.Fprintf(out, "    %s to %s\n", occurrence.Start.Format(time.RFC3339), occurrence.End.Format(time.RFC3339))
+			}
+		}
+		for _, warning := range result.Warnings {
+			fmt.Fprintf(out, "  Warning:   %s\n", warning)
+		}
+		for _, err := range result.Errors {
+			fmt.Fprintf(out, "  Error:     %s\n", err)
+		}
+		if !result.Valid() {
+			invalid++
+		}
+	}
+	if len(results) > 0 {
+		fmt.Fprintln(out)
+	}
+	fmt.Fprintf(out, "%d disruption windows checked, %d with errors\n", len(results), invalid)
+}